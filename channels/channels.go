@@ -0,0 +1,83 @@
+package channels
+
+import (
+	"fmt"
+	"prisma-webhook/config"
+	"prisma-webhook/models"
+	"prisma-webhook/templates"
+	"sync"
+)
+
+// Channel is a configured channel with its task and card templates loaded
+type Channel struct {
+	config.Channel
+
+	Tasks *templates.TaskRenderer
+	Card  *templates.CardTemplate
+}
+
+// Accepts reports whether the channel's filter lets the alert through
+func (ch *Channel) Accepts(alert *models.CustomPrismaAlert) bool {
+	return ch.Filter.Matches(alert, ch.Name)
+}
+
+// Registry holds the configured channels by name
+type Registry struct {
+	mu       sync.RWMutex
+	channels []*Channel
+	byName   map[string]*Channel
+}
+
+// Load builds the channels of cfg, reading and validating their template files
+func Load(cfg *config.Config) (*Registry, error) {
+	r := &Registry{byName: make(map[string]*Channel, len(cfg.Channels))}
+	for _, c := range cfg.Channels {
+		tasks, err := templates.NewTaskRenderer(c.ClickUpTitleTemplate, c.ClickUpDescriptionTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("channel %s: %w", c.Name, err)
+		}
+
+		card, err := templates.LoadCardTemplate(c.TeamsCardTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("channel %s: %w", c.Name, err)
+		}
+
+		ch := &Channel{Channel: c, Tasks: tasks, Card: card}
+		r.channels = append(r.channels, ch)
+		r.byName[c.Name] = ch
+	}
+	return r, nil
+}
+
+// Get returns the channel called name, or nil if there is none
+func (r *Registry) Get(name string) *Channel {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.byName[name]
+}
+
+// Has reports whether a channel called name is configured
+func (r *Registry) Has(name string) bool {
+	return r.Get(name) != nil
+}
+
+// All returns the channels in configuration order
+func (r *Registry) All() []*Channel {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.channels
+}
+
+// Replace switches to the channels of other, e.g. after the configuration was reloaded
+func (r *Registry) Replace(other *Registry) {
+	other.mu.RLock()
+	channels, byName := other.channels, other.byName
+	other.mu.RUnlock()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.channels, r.byName = channels, byName
+}
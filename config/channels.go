@@ -0,0 +1,109 @@
+package config
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// channelName is the form of a channel name, usable in the /webhook/{channel} path
+var channelName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// getChannels reads the channels named in CHANNELS. The settings of a channel are
+// read from variables carrying its name, e.g. CLICKUP_<NAME>_LIST_ID for "name";
+// the assignees and task templates default to the global CLICKUP_ variables.
+func (l *loader) getChannels(clickUpEnabled bool, teamsEnabled bool, slackEnabled bool) []Channel {
+	assignees := l.getEnvIntList("CLICKUP_ASSIGNEES")
+	titleTemplate := l.get("CLICKUP_TITLE_TEMPLATE")
+	descriptionTemplate := l.get("CLICKUP_DESCRIPTION_TEMPLATE")
+
+	var channels []Channel
+	seen := make(map[string]string)
+	for _, name := range strings.Split(l.getEnvDefault("CHANNELS", "alerta,mandatory"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !channelName.MatchString(name) {
+			l.errorf("Invalid channel name '%s': use lowercase letters, digits, '-' and '_'", name)
+			continue
+		}
+
+		key := channelEnvKey(name)
+		if other, ok := seen[key]; ok && other == name {
+			l.errorf("Duplicate channel '%s'", name)
+			continue
+		} else if ok {
+			l.errorf("Channels '%s' and '%s' map to the same variables (*_%s_*)", other, name, key)
+			continue
+		}
+		seen[key] = name
+
+		ch := Channel{
+			Name:                       name,
+			ClickUpListID:              l.get("CLICKUP_" + key + "_LIST_ID"),
+			ClickUpAssignees:           assignees,
+			ClickUpTitleTemplate:       l.getOr("CLICKUP_"+key+"_TITLE_TEMPLATE", titleTemplate),
+			ClickUpDescriptionTemplate: l.getOr("CLICKUP_"+key+"_DESCRIPTION_TEMPLATE", descriptionTemplate),
+			TeamsWebhookURL:            l.get("TEAMS_" + key + "_WEBHOOK_URL"),
+			TeamsCardTemplate:          l.get("TEAMS_" + key + "_CARD_TEMPLATE"),
+			SlackWebhookURL:            l.get("SLACK_" + key + "_WEBHOOK_URL"),
+			Filter:                     l.channelFilters[name],
+		}
+		if l.get("CLICKUP_"+key+"_ASSIGNEES") != "" {
+			ch.ClickUpAssignees = l.getEnvIntList("CLICKUP_" + key + "_ASSIGNEES")
+		}
+
+		if clickUpEnabled && ch.ClickUpListID == "" {
+			l.errorf("CLICKUP_%s_LIST_ID is required", key)
+		}
+		if err := ch.Filter.Validate(); err != nil {
+			l.errorf("Invalid filter of channel '%s': %v", name, err)
+		}
+		if teamsEnabled && ch.TeamsWebhookURL != "" {
+			l.infof("Teams %s webhook integration enabled", name)
+		}
+		if slackEnabled && ch.SlackWebhookURL != "" {
+			l.infof("Slack %s webhook integration enabled", name)
+		}
+		channels = append(channels, ch)
+	}
+
+	if len(channels) == 0 {
+		l.errorf("CHANNELS must name at least one channel")
+	}
+	return channels
+}
+
+// channelEnvKey returns the part of a channel's variable names standing for the
+// channel: its name in upper case, with '-' as '_'
+func channelEnvKey(name string) string {
+	return strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// getOr returns the value of key, or def if it is empty. Unlike getEnvDefault, an
+// empty variable does not replace def, which is itself a setting.
+func (l *loader) getOr(key string, def string) string {
+	if v := l.get(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// getEnvIntList parses a comma-separated list of integers such as ClickUp user IDs
+func (l *loader) getEnvIntList(key string) []int {
+	var list []int
+	for _, entry := range strings.Split(l.get(key), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		n, err := strconv.Atoi(entry)
+		if err != nil {
+			l.errorf("Invalid %s entry '%s': not an integer", key, entry)
+			continue
+		}
+		list = append(list, n)
+	}
+	return list
+}
@@ -1,22 +1,118 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"log/slog"
+	"net/netip"
 	"os"
+	"prisma-webhook/routing"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
-	Port                   string
-	ClickUpAPIToken        string
-	ClickUpAlertaListID    string
-	ClickUpMandatoryListID string
-	ClickUpAssignees       []int
-	WebhookAPIKey          string
-	AllowedIPs             []string
+	// Optional YAML or TOML file with the settings; environment variables override it
+	ConfigFile string
+
+	// How often the config, rules and template files are checked for changes (0 = SIGHUP only)
+	ConfigWatchInterval time.Duration
+
+	Port string
+
+	// Per-sink enable flags
+	ClickUpEnabled bool
+	TeamsEnabled   bool
+	SlackEnabled   bool
+
+	// SharePointEnabled is set when the flag is on and all Azure AD fields are configured
+	SharePointEnabled bool
+
+	ClickUpAPIToken string
+
+	// ClickUp task status applied when an alert changes state (empty = leave unchanged)
+	ClickUpResolvedStatus  string
+	ClickUpDismissedStatus string
+	ClickUpSnoozedStatus   string
+	ClickUpReopenStatus    string
+
+	WebhookAPIKey string
+
+	// Named API keys, including WEBHOOK_API_KEY as "default" when set
+	APIKeys []APIKey
+
+	// Webhook authentication: "api_key", "hmac" or "any"
+	WebhookAuthMode           string
+	WebhookSigningSecret      string
+	WebhookSignatureTolerance time.Duration
+
+	AllowedIPs []string
+
+	// Rate limits per route, counted per API key or client IP
+	RateLimitWebhook RateLimit
+	RateLimitGeneral RateLimit
+	RateLimitAdmin   RateLimit
+
+	// Rate limit counters: "memory" or "redis" (shared by replicas)
+	RateLimitStore string
+	RedisURL       string
+
+	// URL or file with more allowed ranges (JSON or plain text), reloaded every AllowedIPsRefresh
+	AllowedIPsSource  string
+	AllowedIPsRefresh time.Duration
+
+	// Reverse proxies whose X-Forwarded-For / X-Real-IP headers are trusted
+	TrustedProxies []string
+
+	// Logging: minimum level and "json" or "text" records
+	LogLevel  slog.Level
+	LogFormat string
+
+	// Log destination ("stdout", "file" or "both") and rotation of the log file
+	LogOutput      string
+	LogFile        string
+	LogMaxSize     int64
+	LogRotateEvery time.Duration
+	LogMaxBackups  int
+	LogMaxAge      time.Duration
+	LogCompress    bool
+
+	// Serve Prometheus metrics on /metrics
+	MetricsEnabled bool
+
+	// Tracing exporter: "none", "otlp" (OTEL_EXPORTER_OTLP_* variables), "stdout" or "file"
+	TracingExporter    string
+	TracingFile        string
+	TracingServiceName string
+	TracingSampleRatio float64
+
+	DataDir string
+
+	// Path to the YAML routing rules file (optional)
+	RoutingRulesFile string
+
+	// Routing rules written in the config file, used instead of RoutingRulesFile
+	RoutingRules []routing.Rule
+
+	// Processing queue
+	QueueWorkers     int
+	QueueSize        int
+	JobRetention     time.Duration
+	HistoryRetention time.Duration
+	RetryMaxAttempts int
+	RetryBaseDelay   time.Duration
+	RetryMaxDelay    time.Duration
+
+	// How long a shutdown waits for requests and queued jobs to finish
+	ShutdownTimeout time.Duration
+
+	// Readiness checks: how long results are cached and how long one check may take
+	ReadyCacheTTL     time.Duration
+	ReadyCheckTimeout time.Duration
 
 	// Azure AD / Microsoft Graph
 	AzureTenantID     string
@@ -24,104 +120,651 @@ type Config struct {
 	AzureClientSecret string
 	SharePointSiteID  string
 
-	// Microsoft Teams
-	TeamsAlertaWebhookURL    string
-	TeamsMandatoryWebhookURL string
+	// One SharePoint page per "alert" or per "policy"
+	SharePointPageMode string
+
+	// Graph and Azure AD endpoints, overridable to run against a local stand-in
+	GraphBaseURL      string
+	AzureAuthorityURL string
+
+	// Channels webhooks are delivered to, selected by X-Type or /webhook/{channel}
+	Channels []Channel
+}
+
+// Channel is a named alert destination. Each Prisma Cloud alert rule posts to one
+// channel, which decides the ClickUp list, Teams and Slack webhooks and templates.
+type Channel struct {
+	Name string
+
+	ClickUpListID    string
+	ClickUpAssignees []int
+
+	// Go text/template files for the ClickUp task (optional, built-in layout if empty)
+	ClickUpTitleTemplate       string
+	ClickUpDescriptionTemplate string
+
+	TeamsWebhookURL string
+
+	// Adaptive Card template file (optional, built-in card if empty)
+	TeamsCardTemplate string
+
+	SlackWebhookURL string
+
+	// Filter limits the channel to the alerts it matches; the others are skipped
+	Filter routing.Match
+}
+
+// APIKey is one accepted X-API-Key value. Several keys can be valid at the same
+// time, so a key can be rotated without downtime.
+type APIKey struct {
+	ID        string
+	Key       string
+	ExpiresAt time.Time // zero means the key does not expire
+
+	// Scopes limits the key to these channels and/or "admin"; empty allows everything
+	Scopes []string
+
+	// RateLimit replaces the route limits for requests made with this key (optional)
+	RateLimit *RateLimit
+}
+
+// RateLimit allows Max requests per Window; a Max of 0 disables the limit
+type RateLimit struct {
+	Max    int
+	Window time.Duration
+}
+
+func (r RateLimit) String() string {
+	return fmt.Sprintf("%d/%s", r.Max, r.Window)
+}
+
+// Expired reports whether the key is past its expiry
+func (k APIKey) Expired(now time.Time) bool {
+	return !k.ExpiresAt.IsZero() && now.After(k.ExpiresAt)
 }
 
+// Allows reports whether the key may be used for scope
+func (k APIKey) Allows(scope string) bool {
+	if len(k.Scopes) == 0 {
+		return true
+	}
+	for _, s := range k.Scopes {
+		if strings.EqualFold(s, scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// Load reads the configuration from the environment (and a .env file) and the
+// optional CONFIG_FILE, exiting with every invalid setting listed if it is not valid
 func Load() *Config {
 	// Load .env file if it exists
 	if err := godotenv.Load(); err != nil {
 		log.Println("No .env file found, using environment variables")
 	}
 
-	port := os.Getenv("PORT")
+	cfg, err := load(true)
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n  %s", strings.ReplaceAll(err.Error(), "\n", "\n  "))
+	}
+	return cfg
+}
+
+// Reload reads the configuration again like Load, returning the errors instead of exiting
+func Reload() (*Config, error) {
+	return load(false)
+}
+
+func load(verbose bool) (*Config, error) {
+	l := &loader{verbose: verbose}
+
+	// the config file itself can only be named by the environment
+	configFile := os.Getenv("CONFIG_FILE")
+	if configFile != "" {
+		file, errs := readConfigFile(configFile)
+		l.errs = append(l.errs, errs...)
+		if file != nil {
+			l.file = file.values
+			l.routingRules = file.rules
+			l.channelFilters = file.filters
+			l.infof("Configuration file %s loaded", configFile)
+		}
+	}
+
+	port := l.getEnvDefault("PORT", "8080")
 	if port == "" {
 		port = "8080"
 	}
 
-	clickUpToken := os.Getenv("CLICKUP_API_TOKEN")
-	if clickUpToken == "" {
-		log.Fatal("CLICKUP_API_TOKEN is required")
+	clickUpEnabled := l.getEnvBool("CLICKUP_ENABLED", true)
+	teamsEnabled := l.getEnvBool("TEAMS_ENABLED", true)
+	slackEnabled := l.getEnvBool("SLACK_ENABLED", true)
+
+	clickUpToken := l.get("CLICKUP_API_TOKEN")
+	if clickUpEnabled && clickUpToken == "" {
+		l.errorf("CLICKUP_API_TOKEN is required")
 	}
 
-	clickUpAlertaListID := os.Getenv("CLICKUP_ALERTA_LIST_ID")
-	if clickUpAlertaListID == "" {
-		log.Fatal("CLICKUP_ALERTA_LIST_ID is required")
+	if !clickUpEnabled {
+		l.infof("ClickUp integration disabled")
 	}
 
-	clickUpMandatoryListID := os.Getenv("CLICKUP_MANDATORY_LIST_ID")
-	if clickUpMandatoryListID == "" {
-		log.Fatal("CLICKUP_MANDATORY_LIST_ID is required")
+	// Alert lifecycle statuses
+	resolvedStatus := l.getEnvDefault("CLICKUP_RESOLVED_STATUS", "Closed")
+	dismissedStatus := l.getEnvDefault("CLICKUP_DISMISSED_STATUS", "Closed")
+	snoozedStatus := l.get("CLICKUP_SNOOZED_STATUS")
+	reopenStatus := l.getEnvDefault("CLICKUP_REOPEN_STATUS", "Open")
+
+	webhookAPIKey := l.get("WEBHOOK_API_KEY")
+	apiKeys, err := parseAPIKeys(l.get("WEBHOOK_API_KEYS"))
+	if err != nil {
+		l.errorf("Invalid WEBHOOK_API_KEYS: %v", err)
+	}
+	if webhookAPIKey != "" {
+		apiKeys = append([]APIKey{{ID: "default", Key: webhookAPIKey}}, apiKeys...)
 	}
+	if len(apiKeys) == 0 {
+		l.errorf("WEBHOOK_API_KEY or WEBHOOK_API_KEYS is required")
+	}
+	for _, k := range apiKeys {
+		switch {
+		case k.Expired(time.Now()):
+			l.warnf("API key '%s' expired on %s", k.ID, k.ExpiresAt.Format(time.RFC3339))
+		case !k.ExpiresAt.IsZero() && time.Until(k.ExpiresAt) < 7*24*time.Hour:
+			l.warnf("API key '%s' expires on %s", k.ID, k.ExpiresAt.Format(time.RFC3339))
+		}
+	}
+	l.infof("%d API key(s) configured", len(apiKeys))
 
-	assigneesStr := os.Getenv("CLICKUP_ASSIGNEES")
-	var assignees []int
-	if assigneesStr != "" {
-		assigneeStrs := strings.Split(assigneesStr, ",")
-		for _, idStr := range assigneeStrs {
-			id, err := strconv.Atoi(strings.TrimSpace(idStr))
-			if err != nil {
-				log.Printf("Warning: Invalid assignee ID '%s', skipping", idStr)
-				continue
-			}
-			assignees = append(assignees, id)
+	webhookAuthMode := strings.ToLower(l.getEnvDefault("WEBHOOK_AUTH_MODE", "api_key"))
+	switch webhookAuthMode {
+	case "api_key", "hmac", "any":
+	default:
+		l.errorf("WEBHOOK_AUTH_MODE must be api_key, hmac or any, got '%s'", webhookAuthMode)
+	}
+
+	webhookSigningSecret := l.get("WEBHOOK_SIGNING_SECRET")
+	if webhookAuthMode != "api_key" && webhookSigningSecret == "" {
+		l.errorf("WEBHOOK_SIGNING_SECRET is required when WEBHOOK_AUTH_MODE is hmac or any")
+	}
+	webhookSignatureTolerance := l.getEnvDuration("WEBHOOK_SIGNATURE_TOLERANCE", 5*time.Minute)
+
+	allowedIPs := l.getEnvIPList("ALLOWED_IPS")
+	allowedIPsSource := l.get("ALLOWED_IPS_SOURCE")
+	allowedIPsRefresh := l.getEnvDuration("ALLOWED_IPS_REFRESH", time.Hour)
+	if allowedIPsSource != "" {
+		l.infof("IP allowlist ranges loaded from %s every %s", allowedIPsSource, allowedIPsRefresh)
+	}
+
+	if len(allowedIPs) > 0 {
+		l.infof("IP allowlist enabled with %d IP(s)/range(s)", len(allowedIPs))
+	} else if allowedIPsSource == "" {
+		l.warnf("No IP allowlist configured. All IPs will be allowed.")
+	}
+
+	// Rate limits
+	rateLimitWebhook := l.getEnvRateLimit("RATE_LIMIT_WEBHOOK", RateLimit{Max: 100, Window: time.Minute})
+	rateLimitGeneral := l.getEnvRateLimit("RATE_LIMIT_GENERAL", RateLimit{Max: 60, Window: time.Minute})
+	rateLimitAdmin := l.getEnvRateLimit("RATE_LIMIT_ADMIN", rateLimitGeneral)
+
+	rateLimitStore := strings.ToLower(l.getEnvDefault("RATE_LIMIT_STORE", "memory"))
+	redisURL := l.get("REDIS_URL")
+	switch rateLimitStore {
+	case "memory":
+	case "redis":
+		if redisURL == "" {
+			l.errorf("REDIS_URL is required when RATE_LIMIT_STORE is redis")
 		}
+		l.infof("Rate limit counters shared through Redis")
+	default:
+		l.errorf("RATE_LIMIT_STORE must be memory or redis, got '%s'", rateLimitStore)
+	}
+
+	trustedProxies := l.getEnvIPList("TRUSTED_PROXIES")
+	if len(trustedProxies) > 0 {
+		l.infof("Trusting X-Forwarded-For from %d proxy IP(s)/range(s)", len(trustedProxies))
 	}
 
-	webhookAPIKey := os.Getenv("WEBHOOK_API_KEY")
-	if webhookAPIKey == "" {
-		log.Fatal("WEBHOOK_API_KEY is required")
+	var logLevel slog.Level
+	if err := logLevel.UnmarshalText([]byte(l.getEnvDefault("LOG_LEVEL", "info"))); err != nil {
+		l.errorf("LOG_LEVEL must be debug, info, warn or error: %v", err)
 	}
+	logFormat := strings.ToLower(l.getEnvDefault("LOG_FORMAT", "json"))
+	if logFormat != "json" && logFormat != "text" {
+		l.errorf("LOG_FORMAT must be json or text, got '%s'", logFormat)
+	}
+
+	logOutput := strings.ToLower(l.getEnvDefault("LOG_OUTPUT", "stdout"))
+	if logOutput != "stdout" && logOutput != "file" && logOutput != "both" {
+		l.errorf("LOG_OUTPUT must be stdout, file or both, got '%s'", logOutput)
+	}
+	logFile := l.getEnvDefault("LOG_FILE", "logs/webhook.log")
+	logMaxSize := l.getEnvSize("LOG_MAX_SIZE", 100<<20)
+	logRotateEvery := l.getEnvDuration("LOG_ROTATE_EVERY", 0)
+	logMaxBackups := l.getEnvInt("LOG_MAX_BACKUPS", 10)
+	logMaxAge := l.getEnvDuration("LOG_MAX_AGE", 0)
+	logCompress := l.getEnvBool("LOG_COMPRESS", true)
 
-	var allowedIPs []string
-	allowedIPsStr := os.Getenv("ALLOWED_IPS")
-	if allowedIPsStr != "" {
-		ipStrs := strings.Split(allowedIPsStr, ",")
-		for _, ip := range ipStrs {
-			allowedIPs = append(allowedIPs, strings.TrimSpace(ip))
+	metricsEnabled := l.getEnvBool("METRICS_ENABLED", true)
+
+	// Tracing (optional)
+	tracingExporter := strings.ToLower(l.getEnvDefault("TRACING_EXPORTER", "none"))
+	tracingFile := l.get("TRACING_FILE")
+	switch tracingExporter {
+	case "none":
+	case "otlp", "stdout":
+		l.infof("Tracing enabled with the %s exporter", tracingExporter)
+	case "file":
+		if tracingFile == "" {
+			l.errorf("TRACING_FILE is required when TRACING_EXPORTER is file")
 		}
-		log.Printf("IP allowlist enabled with %d IP(s)", len(allowedIPs))
-	} else {
-		log.Println("Warning: No IP allowlist configured. All IPs will be allowed.")
+		l.infof("Tracing enabled, writing spans to %s", tracingFile)
+	default:
+		l.errorf("TRACING_EXPORTER must be none, otlp, stdout or file, got '%s'", tracingExporter)
+	}
+	tracingServiceName := l.getEnvDefault("OTEL_SERVICE_NAME", "prisma-webhook")
+	tracingSampleRatio := l.getEnvRatio("TRACING_SAMPLE_RATIO", 1)
+
+	dataDir := l.get("DATA_DIR")
+	if dataDir == "" {
+		dataDir = "data"
 	}
 
+	routingRulesFile := l.get("ROUTING_RULES_FILE")
+	if routingRulesFile != "" {
+		l.infof("Routing rules enabled from %s", routingRulesFile)
+	}
+
+	// Processing queue and retries
+	queueWorkers := l.getEnvInt("QUEUE_WORKERS", 4)
+	queueSize := l.getEnvInt("QUEUE_SIZE", 1000)
+	jobRetention := l.getEnvDuration("JOB_RETENTION", 7*24*time.Hour)
+	historyRetention := l.getEnvDuration("HISTORY_RETENTION", 30*24*time.Hour)
+	retryMaxAttempts := l.getEnvInt("RETRY_MAX_ATTEMPTS", 5)
+	retryBaseDelay := l.getEnvDuration("RETRY_BASE_DELAY", time.Second)
+	retryMaxDelay := l.getEnvDuration("RETRY_MAX_DELAY", 30*time.Second)
+	shutdownTimeout := l.getEnvDuration("SHUTDOWN_TIMEOUT", 25*time.Second)
+
+	// Readiness checks behind /ready
+	readyCacheTTL := l.getEnvDuration("READY_CACHE_TTL", 30*time.Second)
+	readyCheckTimeout := l.getEnvDuration("READY_CHECK_TIMEOUT", 5*time.Second)
+
 	// Azure AD / Microsoft Graph (optional)
-	azureTenantID := os.Getenv("AZURE_TENANT_ID")
-	azureClientID := os.Getenv("AZURE_CLIENT_ID")
-	azureClientSecret := os.Getenv("AZURE_CLIENT_SECRET")
-	sharePointSiteID := os.Getenv("SHAREPOINT_SITE_ID")
+	azureTenantID := l.get("AZURE_TENANT_ID")
+	azureClientID := l.get("AZURE_CLIENT_ID")
+	azureClientSecret := l.get("AZURE_CLIENT_SECRET")
+	sharePointSiteID := l.get("SHAREPOINT_SITE_ID")
 
-	if azureTenantID != "" && azureClientID != "" && azureClientSecret != "" && sharePointSiteID != "" {
-		log.Println("SharePoint integration enabled")
+	sharePointEnabled := false
+	if !l.getEnvBool("SHAREPOINT_ENABLED", true) {
+		l.infof("SharePoint integration disabled")
+	} else if azureTenantID != "" && azureClientID != "" && azureClientSecret != "" && sharePointSiteID != "" {
+		sharePointEnabled = true
+		l.infof("SharePoint integration enabled")
 	} else if azureTenantID != "" || azureClientID != "" || azureClientSecret != "" || sharePointSiteID != "" {
-		log.Println("Warning: SharePoint integration partially configured. All Azure AD fields are required.")
+		l.warnf("SharePoint integration partially configured. All Azure AD fields are required.")
 	}
 
-	// Microsoft Teams Webhook (optional)
-	teamsAlertaWebhookURL := os.Getenv("TEAMS_ALERTA_WEBHOOK_URL")
-	if teamsAlertaWebhookURL != "" {
-		log.Println("Teams alerta webhook integration enabled")
+	sharePointPageMode := strings.ToLower(l.getOr("SHAREPOINT_PAGE_MODE", "alert"))
+	if sharePointPageMode != "alert" && sharePointPageMode != "policy" {
+		l.errorf("SHAREPOINT_PAGE_MODE must be alert or policy, got '%s'", sharePointPageMode)
 	}
 
-	teamsMandatoryWebhookURL := os.Getenv("TEAMS_MANDATORY_WEBHOOK_URL")
-	if teamsMandatoryWebhookURL != "" {
-		log.Println("Teams mandatory webhook integration enabled")
+	graphBaseURL := l.getEnvDefault("GRAPH_BASE_URL", "https://graph.microsoft.com/v1.0")
+	azureAuthorityURL := l.getEnvDefault("AZURE_AUTHORITY_URL", "https://login.microsoftonline.com")
+
+	if !teamsEnabled {
+		l.infof("Teams integration disabled")
+	}
+	if !slackEnabled {
+		l.infof("Slack integration disabled")
+	}
+
+	// Channels, each with its ClickUp list, Teams/Slack webhooks and templates
+	channels := l.getChannels(clickUpEnabled, teamsEnabled, slackEnabled)
+
+	// Routing rules come from ROUTING_RULES_FILE or from the config file's routing.rules
+	routingRules := l.routingRules
+	if len(routingRules) > 0 {
+		if routingRulesFile != "" {
+			l.errorf("routing.rules and ROUTING_RULES_FILE cannot both be set")
+		}
+		if _, err := routing.NewEngine(routingRules); err != nil {
+			l.errorf("Invalid routing.rules: %v", err)
+		}
+		l.infof("%d routing rule(s) loaded from the configuration file", len(routingRules))
+	}
+
+	configWatchInterval := l.getEnvDuration("CONFIG_WATCH_INTERVAL", 10*time.Second)
+
+	if err := errors.Join(l.errs...); err != nil {
+		return nil, err
 	}
 
 	return &Config{
-		Port:                     port,
-		ClickUpAPIToken:          clickUpToken,
-		ClickUpAlertaListID:      clickUpAlertaListID,
-		ClickUpMandatoryListID:   clickUpMandatoryListID,
-		ClickUpAssignees:         assignees,
-		WebhookAPIKey:            webhookAPIKey,
-		AllowedIPs:               allowedIPs,
-		AzureTenantID:            azureTenantID,
-		AzureClientID:            azureClientID,
-		AzureClientSecret:        azureClientSecret,
-		SharePointSiteID:         sharePointSiteID,
-		TeamsAlertaWebhookURL:    teamsAlertaWebhookURL,
-		TeamsMandatoryWebhookURL: teamsMandatoryWebhookURL,
+		ConfigFile:                configFile,
+		ConfigWatchInterval:       configWatchInterval,
+		Port:                      port,
+		ClickUpEnabled:            clickUpEnabled,
+		TeamsEnabled:              teamsEnabled,
+		SlackEnabled:              slackEnabled,
+		SharePointEnabled:         sharePointEnabled,
+		ClickUpAPIToken:           clickUpToken,
+		ClickUpResolvedStatus:     resolvedStatus,
+		ClickUpDismissedStatus:    dismissedStatus,
+		ClickUpSnoozedStatus:      snoozedStatus,
+		ClickUpReopenStatus:       reopenStatus,
+		WebhookAPIKey:             webhookAPIKey,
+		APIKeys:                   apiKeys,
+		WebhookAuthMode:           webhookAuthMode,
+		WebhookSigningSecret:      webhookSigningSecret,
+		WebhookSignatureTolerance: webhookSignatureTolerance,
+		AllowedIPs:                allowedIPs,
+		AllowedIPsSource:          allowedIPsSource,
+		AllowedIPsRefresh:         allowedIPsRefresh,
+		TrustedProxies:            trustedProxies,
+		LogLevel:                  logLevel,
+		LogFormat:                 logFormat,
+		LogOutput:                 logOutput,
+		LogFile:                   logFile,
+		LogMaxSize:                logMaxSize,
+		LogRotateEvery:            logRotateEvery,
+		LogMaxBackups:             logMaxBackups,
+		LogMaxAge:                 logMaxAge,
+		LogCompress:               logCompress,
+		MetricsEnabled:            metricsEnabled,
+		TracingExporter:           tracingExporter,
+		TracingFile:               tracingFile,
+		TracingServiceName:        tracingServiceName,
+		TracingSampleRatio:        tracingSampleRatio,
+		RateLimitWebhook:          rateLimitWebhook,
+		RateLimitGeneral:          rateLimitGeneral,
+		RateLimitAdmin:            rateLimitAdmin,
+		RateLimitStore:            rateLimitStore,
+		RedisURL:                  redisURL,
+		DataDir:                   dataDir,
+		RoutingRulesFile:          routingRulesFile,
+		RoutingRules:              routingRules,
+		QueueWorkers:              queueWorkers,
+		QueueSize:                 queueSize,
+		JobRetention:              jobRetention,
+		HistoryRetention:          historyRetention,
+		RetryMaxAttempts:          retryMaxAttempts,
+		RetryBaseDelay:            retryBaseDelay,
+		RetryMaxDelay:             retryMaxDelay,
+		ShutdownTimeout:           shutdownTimeout,
+		ReadyCacheTTL:             readyCacheTTL,
+		ReadyCheckTimeout:         readyCheckTimeout,
+		AzureTenantID:             azureTenantID,
+		AzureClientID:             azureClientID,
+		AzureClientSecret:         azureClientSecret,
+		SharePointSiteID:          sharePointSiteID,
+		SharePointPageMode:        sharePointPageMode,
+		GraphBaseURL:              graphBaseURL,
+		AzureAuthorityURL:         azureAuthorityURL,
+		Channels:                  channels,
+	}, nil
+}
+
+// loader resolves settings by environment variable name: a non-empty variable wins
+// over the config file, which wins over the default. Errors are collected so that
+// every invalid setting is reported at once.
+type loader struct {
+	file         map[string]string
+	routingRules []routing.Rule
+	errs         []error
+
+	// channel filters by channel name, only written in the config file
+	channelFilters map[string]routing.Match
+
+	// verbose logs which integrations are enabled, skipped on reloads
+	verbose bool
+}
+
+// lookup returns the value of key and whether it is set. An empty variable only
+// replaces the default, so a .env copied from .env.example cannot blank the file.
+func (l *loader) lookup(key string) (string, bool) {
+	env, set := os.LookupEnv(key)
+	if env != "" {
+		return env, true
+	}
+	if v, ok := l.file[key]; ok {
+		return v, true
+	}
+	return env, set
+}
+
+func (l *loader) get(key string) string {
+	v, _ := l.lookup(key)
+	return v
+}
+
+func (l *loader) errorf(format string, args ...interface{}) {
+	l.errs = append(l.errs, fmt.Errorf(format, args...))
+}
+
+func (l *loader) warnf(format string, args ...interface{}) {
+	if !l.verbose {
+		// reloads happen after logging was set up
+		slog.Warn(fmt.Sprintf(format, args...))
+		return
+	}
+	log.Printf("Warning: "+format, args...)
+}
+
+func (l *loader) infof(format string, args ...interface{}) {
+	if l.verbose {
+		log.Printf(format, args...)
+	}
+}
+
+// parseAPIKeys parses a comma-separated key list. Each entry is
+// "id:key" optionally followed by ";expires=<RFC3339 or 2006-01-02>",
+// ";scopes=alerta|mandatory|admin" (channels and/or admin) and ";rate=500/1m".
+func parseAPIKeys(v string) ([]APIKey, error) {
+	var keys []APIKey
+	seen := map[string]bool{}
+
+	for i, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.Split(entry, ";")
+		id, key, ok := strings.Cut(strings.TrimSpace(parts[0]), ":")
+		id, key = strings.TrimSpace(id), strings.TrimSpace(key)
+		if !ok || id == "" || key == "" {
+			return nil, fmt.Errorf("entry %d must start with id:key", i+1)
+		}
+		if seen[id] || id == "default" {
+			return nil, fmt.Errorf("duplicate key ID '%s'", id)
+		}
+		seen[id] = true
+
+		k := APIKey{ID: id, Key: key}
+		for _, opt := range parts[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(opt), "=")
+			switch strings.TrimSpace(name) {
+			case "expires":
+				t, err := parseTime(strings.TrimSpace(value))
+				if err != nil {
+					return nil, fmt.Errorf("key '%s': invalid expires '%s'", id, value)
+				}
+				k.ExpiresAt = t
+			case "scopes":
+				for _, s := range strings.Split(value, "|") {
+					if s = strings.TrimSpace(s); s != "" {
+						k.Scopes = append(k.Scopes, s)
+					}
+				}
+			case "rate":
+				limit, err := parseRateLimit(strings.TrimSpace(value))
+				if err != nil {
+					return nil, fmt.Errorf("key '%s': %w", id, err)
+				}
+				k.RateLimit = &limit
+			default:
+				return nil, fmt.Errorf("key '%s': unknown option '%s'", id, name)
+			}
+		}
+		keys = append(keys, k)
+	}
+
+	return keys, nil
+}
+
+// parseRateLimit parses a limit such as "100/1m" (100 requests per minute)
+func parseRateLimit(v string) (RateLimit, error) {
+	maxStr, windowStr, ok := strings.Cut(v, "/")
+	if !ok {
+		return RateLimit{}, fmt.Errorf("invalid rate limit '%s', expected <requests>/<window> such as 100/1m", v)
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(maxStr))
+	if err != nil || n < 0 {
+		return RateLimit{}, fmt.Errorf("invalid rate limit '%s': bad request count", v)
+	}
+
+	window, err := time.ParseDuration(strings.TrimSpace(windowStr))
+	if err != nil || window <= 0 {
+		return RateLimit{}, fmt.Errorf("invalid rate limit '%s': bad window", v)
+	}
+
+	return RateLimit{Max: n, Window: window}, nil
+}
+
+// getEnvRateLimit parses a rate limit environment variable such as "100/1m", falling back to def if it is unset
+func (l *loader) getEnvRateLimit(key string, def RateLimit) RateLimit {
+	v := l.get(key)
+	if v == "" {
+		return def
+	}
+	limit, err := parseRateLimit(v)
+	if err != nil {
+		l.errorf("Invalid %s: %v", key, err)
+		return def
+	}
+	return limit
+}
+
+// parseTime accepts an RFC3339 timestamp or a date, which means midnight UTC
+func parseTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", v)
+}
+
+// getEnvIPList parses a comma-separated list of IP addresses and CIDR ranges (IPv4 or IPv6).
+// An invalid entry is an error, as it would otherwise silently never match.
+func (l *loader) getEnvIPList(key string) []string {
+	var list []string
+	for _, entry := range strings.Split(l.get(key), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if _, err := netip.ParsePrefix(entry); err != nil {
+			if _, err := netip.ParseAddr(entry); err != nil {
+				l.errorf("Invalid %s entry '%s': not an IP address or CIDR range", key, entry)
+				continue
+			}
+		}
+		list = append(list, entry)
+	}
+	return list
+}
+
+// getEnvDefault returns the value of the environment variable key, or def if it is unset
+func (l *loader) getEnvDefault(key string, def string) string {
+	if v, ok := l.lookup(key); ok {
+		return v
+	}
+	return def
+}
+
+// getEnvBool parses a boolean environment variable such as "true" or "0", falling back to def if it is unset
+func (l *loader) getEnvBool(key string, def bool) bool {
+	v := l.get(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(strings.TrimSpace(v))
+	if err != nil {
+		l.errorf("Invalid %s '%s': use true or false", key, v)
+		return def
+	}
+	return b
+}
+
+// getEnvInt parses a positive integer environment variable, falling back to def if it is unset
+func (l *loader) getEnvInt(key string, def int) int {
+	v := l.get(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil || n <= 0 {
+		l.errorf("Invalid %s '%s': not a positive integer", key, v)
+		return def
+	}
+	return n
+}
+
+// getEnvSize parses a byte size such as "100MB", "512KB" or "1GB" (a plain number is
+// bytes), falling back to def if it is unset. "0" turns size limits off.
+func (l *loader) getEnvSize(key string, def int64) int64 {
+	v := strings.ToUpper(strings.TrimSpace(l.get(key)))
+	if v == "" {
+		return def
+	}
+
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		bytes  int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(v, unit.suffix) {
+			v = strings.TrimSpace(strings.TrimSuffix(v, unit.suffix))
+			multiplier = unit.bytes
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		l.errorf("Invalid %s '%s': use a size such as 512KB, 100MB or 1GB", key, l.get(key))
+		return def
+	}
+	return n * multiplier
+}
+
+// getEnvRatio parses a number between 0 and 1 from the environment, falling back to def if it is unset
+func (l *loader) getEnvRatio(key string, def float64) float64 {
+	v := l.get(key)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil || f < 0 || f > 1 {
+		l.errorf("Invalid %s '%s': not a number between 0 and 1", key, v)
+		return def
+	}
+	return f
+}
+
+// getEnvDuration parses a duration environment variable such as "30s" or "5m", falling back to def if it is unset
+func (l *loader) getEnvDuration(key string, def time.Duration) time.Duration {
+	v := l.get(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(v))
+	if err != nil || d < 0 {
+		l.errorf("Invalid %s '%s': use a duration such as 30s or 5m", key, v)
+		return def
 	}
+	return d
 }
@@ -0,0 +1,347 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"prisma-webhook/routing"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// fileSetting maps a config file setting to the environment variable it stands for
+type fileSetting struct {
+	env string
+
+	// list settings take a list in the file, joined with commas like the variable
+	list bool
+}
+
+// fileSettings is the schema of the config file, by dotted path
+var fileSettings = map[string]fileSetting{
+	"port":    {env: "PORT"},
+	"dataDir": {env: "DATA_DIR"},
+
+	"clickup.enabled":               {env: "CLICKUP_ENABLED"},
+	"clickup.apiToken":              {env: "CLICKUP_API_TOKEN"},
+	"clickup.assignees":             {env: "CLICKUP_ASSIGNEES", list: true},
+	"clickup.statuses.resolved":     {env: "CLICKUP_RESOLVED_STATUS"},
+	"clickup.statuses.dismissed":    {env: "CLICKUP_DISMISSED_STATUS"},
+	"clickup.statuses.snoozed":      {env: "CLICKUP_SNOOZED_STATUS"},
+	"clickup.statuses.reopen":       {env: "CLICKUP_REOPEN_STATUS"},
+	"clickup.templates.title":       {env: "CLICKUP_TITLE_TEMPLATE"},
+	"clickup.templates.description": {env: "CLICKUP_DESCRIPTION_TEMPLATE"},
+	"teams.enabled":                 {env: "TEAMS_ENABLED"},
+	"slack.enabled":                 {env: "SLACK_ENABLED"},
+	"sharepoint.enabled":            {env: "SHAREPOINT_ENABLED"},
+	"sharepoint.tenantId":           {env: "AZURE_TENANT_ID"},
+	"sharepoint.clientId":           {env: "AZURE_CLIENT_ID"},
+	"sharepoint.clientSecret":       {env: "AZURE_CLIENT_SECRET"},
+	"sharepoint.siteId":             {env: "SHAREPOINT_SITE_ID"},
+	"sharepoint.pageMode":           {env: "SHAREPOINT_PAGE_MODE"},
+	"sharepoint.graphBaseUrl":       {env: "GRAPH_BASE_URL"},
+	"sharepoint.authorityUrl":       {env: "AZURE_AUTHORITY_URL"},
+	"routing.rulesFile":             {env: "ROUTING_RULES_FILE"},
+	"auth.mode":                     {env: "WEBHOOK_AUTH_MODE"},
+	"auth.apiKey":                   {env: "WEBHOOK_API_KEY"},
+	"auth.signingSecret":            {env: "WEBHOOK_SIGNING_SECRET"},
+	"auth.signatureTolerance":       {env: "WEBHOOK_SIGNATURE_TOLERANCE"},
+	"network.allowedIps":            {env: "ALLOWED_IPS", list: true},
+	"network.allowedIpsSource":      {env: "ALLOWED_IPS_SOURCE"},
+	"network.allowedIpsRefresh":     {env: "ALLOWED_IPS_REFRESH"},
+	"network.trustedProxies":        {env: "TRUSTED_PROXIES", list: true},
+	"rateLimits.webhook":            {env: "RATE_LIMIT_WEBHOOK"},
+	"rateLimits.general":            {env: "RATE_LIMIT_GENERAL"},
+	"rateLimits.admin":              {env: "RATE_LIMIT_ADMIN"},
+	"rateLimits.store":              {env: "RATE_LIMIT_STORE"},
+	"rateLimits.redisUrl":           {env: "REDIS_URL"},
+	"logging.level":                 {env: "LOG_LEVEL"},
+	"logging.format":                {env: "LOG_FORMAT"},
+	"logging.output":                {env: "LOG_OUTPUT"},
+	"logging.file":                  {env: "LOG_FILE"},
+	"logging.maxSize":               {env: "LOG_MAX_SIZE"},
+	"logging.rotateEvery":           {env: "LOG_ROTATE_EVERY"},
+	"logging.maxBackups":            {env: "LOG_MAX_BACKUPS"},
+	"logging.maxAge":                {env: "LOG_MAX_AGE"},
+	"logging.compress":              {env: "LOG_COMPRESS"},
+	"metrics.enabled":               {env: "METRICS_ENABLED"},
+	"tracing.exporter":              {env: "TRACING_EXPORTER"},
+	"tracing.file":                  {env: "TRACING_FILE"},
+	"tracing.serviceName":           {env: "OTEL_SERVICE_NAME"},
+	"tracing.sampleRatio":           {env: "TRACING_SAMPLE_RATIO"},
+	"queue.workers":                 {env: "QUEUE_WORKERS"},
+	"queue.size":                    {env: "QUEUE_SIZE"},
+	"queue.jobRetention":            {env: "JOB_RETENTION"},
+	"queue.historyRetention":        {env: "HISTORY_RETENTION"},
+	"retry.maxAttempts":             {env: "RETRY_MAX_ATTEMPTS"},
+	"retry.baseDelay":               {env: "RETRY_BASE_DELAY"},
+	"retry.maxDelay":                {env: "RETRY_MAX_DELAY"},
+	"shutdownTimeout":               {env: "SHUTDOWN_TIMEOUT"},
+	"ready.cacheTtl":                {env: "READY_CACHE_TTL"},
+	"ready.checkTimeout":            {env: "READY_CHECK_TIMEOUT"},
+	"reload.interval":               {env: "CONFIG_WATCH_INTERVAL"},
+}
+
+// Settings holding lists of objects, which have no single-variable form in fileSettings
+const (
+	apiKeysSetting      = "auth.apiKeys"
+	routingRulesSetting = "routing.rules"
+	channelsSetting     = "channels"
+)
+
+// configFile is the content of a config file: settings by environment variable,
+// plus the settings that can only be written in the file
+type configFile struct {
+	values  map[string]string
+	rules   []routing.Rule
+	filters map[string]routing.Match
+}
+
+// fileAPIKey is an entry of auth.apiKeys, the structured form of WEBHOOK_API_KEYS
+type fileAPIKey struct {
+	ID      string   `yaml:"id"`
+	Key     string   `yaml:"key"`
+	Expires string   `yaml:"expires"`
+	Scopes  []string `yaml:"scopes"`
+	Rate    string   `yaml:"rate"`
+}
+
+// fileChannel is an entry of channels, the structured form of CHANNELS and the
+// per-channel variables
+type fileChannel struct {
+	Name    string `yaml:"name"`
+	ClickUp struct {
+		ListID              string `yaml:"listId"`
+		Assignees           []int  `yaml:"assignees"`
+		TitleTemplate       string `yaml:"titleTemplate"`
+		DescriptionTemplate string `yaml:"descriptionTemplate"`
+	} `yaml:"clickup"`
+	Teams struct {
+		WebhookURL   string `yaml:"webhookUrl"`
+		CardTemplate string `yaml:"cardTemplate"`
+	} `yaml:"teams"`
+	Slack struct {
+		WebhookURL string `yaml:"webhookUrl"`
+	} `yaml:"slack"`
+	Filter routing.Match `yaml:"filter"`
+}
+
+// readConfigFile reads a YAML (.yaml, .yml) or TOML (.toml) config file. Every unknown
+// setting and value of the wrong shape is reported, not only the first.
+func readConfigFile(path string) (*configFile, []error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, []error{fmt.Errorf("failed to read config file: %w", err)}
+	}
+
+	var tree map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &tree)
+	case ".toml":
+		err = toml.Unmarshal(data, &tree)
+	default:
+		return nil, []error{fmt.Errorf("config file %s must end in .yaml, .yml or .toml", path)}
+	}
+	if err != nil {
+		return nil, []error{fmt.Errorf("failed to parse config file %s: %w", path, err)}
+	}
+
+	f := &configFile{values: make(map[string]string)}
+	errs := f.collect("", tree)
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return f, errs
+}
+
+// collect walks the settings below prefix, converting known ones to variable values
+func (f *configFile) collect(prefix string, tree map[string]interface{}) []error {
+	var errs []error
+	for key, value := range tree {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+
+		switch path {
+		case apiKeysSetting:
+			if err := f.collectAPIKeys(value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", path, err))
+			}
+			continue
+		case routingRulesSetting:
+			if err := decodeStrict(value, &f.rules); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", path, err))
+			}
+			continue
+		case channelsSetting:
+			if err := f.collectChannels(value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", path, err))
+			}
+			continue
+		}
+
+		if section, ok := value.(map[string]interface{}); ok {
+			if _, known := fileSettings[path]; known {
+				errs = append(errs, fmt.Errorf("%s: must be a value, not a section", path))
+				continue
+			}
+			errs = append(errs, f.collect(path, section)...)
+			continue
+		}
+
+		setting, ok := fileSettings[path]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: unknown setting", path))
+			continue
+		}
+
+		v, err := settingValue(value, setting.list)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+			continue
+		}
+		f.values[setting.env] = v
+	}
+	return errs
+}
+
+// settingValue converts a scalar, or for list settings a list of scalars, to its
+// variable form
+func settingValue(value interface{}, list bool) (string, error) {
+	items, isList := value.([]interface{})
+	if !list {
+		if isList {
+			return "", errors.New("must be a single value, not a list")
+		}
+		return scalar(value)
+	}
+
+	if !isList {
+		items = []interface{}{value}
+	}
+	parts := make([]string, 0, len(items))
+	for _, item := range items {
+		s, err := scalar(item)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, ","), nil
+}
+
+func scalar(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string, bool, int, int64, uint64, float64:
+		return fmt.Sprint(v), nil
+	default:
+		return "", fmt.Errorf("unsupported value %v", v)
+	}
+}
+
+// collectAPIKeys converts auth.apiKeys to the WEBHOOK_API_KEYS format, so the keys
+// are validated by the same parser
+func (f *configFile) collectAPIKeys(value interface{}) error {
+	var keys []fileAPIKey
+	if err := decodeStrict(value, &keys); err != nil {
+		return err
+	}
+
+	entries := make([]string, 0, len(keys))
+	for i, k := range keys {
+		if strings.ContainsAny(k.ID+k.Key, ",;") {
+			return fmt.Errorf("entry %d: id and key must not contain ',' or ';'", i+1)
+		}
+
+		entry := k.ID + ":" + k.Key
+		if k.Expires != "" {
+			entry += ";expires=" + k.Expires
+		}
+		if len(k.Scopes) > 0 {
+			entry += ";scopes=" + strings.Join(k.Scopes, "|")
+		}
+		if k.Rate != "" {
+			entry += ";rate=" + k.Rate
+		}
+		entries = append(entries, entry)
+	}
+	f.values["WEBHOOK_API_KEYS"] = strings.Join(entries, ",")
+	return nil
+}
+
+// collectChannels converts channels to CHANNELS and the variables of each channel,
+// keeping the filters, which have no variable form
+func (f *configFile) collectChannels(value interface{}) error {
+	var channels []fileChannel
+	if err := decodeStrict(value, &channels); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(channels))
+	f.filters = make(map[string]routing.Match, len(channels))
+	for i, ch := range channels {
+		if ch.Name == "" || strings.Contains(ch.Name, ",") {
+			return fmt.Errorf("entry %d: name is required and must not contain ','", i+1)
+		}
+		names = append(names, ch.Name)
+		f.filters[ch.Name] = ch.Filter
+
+		key := channelEnvKey(ch.Name)
+		for env, v := range map[string]string{
+			"CLICKUP_" + key + "_LIST_ID":              ch.ClickUp.ListID,
+			"CLICKUP_" + key + "_TITLE_TEMPLATE":       ch.ClickUp.TitleTemplate,
+			"CLICKUP_" + key + "_DESCRIPTION_TEMPLATE": ch.ClickUp.DescriptionTemplate,
+			"TEAMS_" + key + "_WEBHOOK_URL":            ch.Teams.WebhookURL,
+			"TEAMS_" + key + "_CARD_TEMPLATE":          ch.Teams.CardTemplate,
+			"SLACK_" + key + "_WEBHOOK_URL":            ch.Slack.WebhookURL,
+		} {
+			if v != "" {
+				f.values[env] = v
+			}
+		}
+		if ch.ClickUp.Assignees != nil {
+			ids := make([]string, len(ch.ClickUp.Assignees))
+			for j, id := range ch.ClickUp.Assignees {
+				ids[j] = strconv.Itoa(id)
+			}
+			f.values["CLICKUP_"+key+"_ASSIGNEES"] = strings.Join(ids, ",")
+		}
+	}
+	f.values["CHANNELS"] = strings.Join(names, ",")
+	return nil
+}
+
+// decodeStrict decodes a parsed YAML or TOML value into out, rejecting unknown fields
+func decodeStrict(value interface{}, out interface{}) error {
+	data, err := yaml.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	err = dec.Decode(out)
+
+	// the line numbers and Go types in yaml's messages mean nothing to the user
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		msgs := make([]string, len(typeErr.Errors))
+		for i, msg := range typeErr.Errors {
+			msgs[i] = yamlDetail.ReplaceAllString(msg, "")
+		}
+		return errors.New(strings.Join(msgs, "; "))
+	}
+	return err
+}
+
+// yamlDetail matches the parts of yaml's decode errors that refer to the re-encoded value
+var yamlDetail = regexp.MustCompile(`^line \d+: | in type \S+$`)
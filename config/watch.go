@@ -0,0 +1,154 @@
+package config
+
+import (
+	"log/slog"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Watcher reloads the configuration on a signal or when the config file or one of
+// the files it names (routing rules, templates) changes, and passes each valid
+// result to its handler. An invalid configuration is logged and ignored.
+type Watcher struct {
+	apply func(current *Config, next *Config) error
+
+	mu      sync.Mutex
+	current *Config
+	files   map[string]fileState
+
+	done chan struct{}
+	once sync.Once
+}
+
+// fileState identifies a version of a watched file
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// NewWatcher creates a watcher for the running configuration cfg. apply switches the
+// service from the current configuration, the one last applied, to a reloaded one;
+// when it fails, the current one stays in effect.
+func NewWatcher(cfg *Config, apply func(current *Config, next *Config) error) *Watcher {
+	w := &Watcher{
+		apply:   apply,
+		current: cfg,
+		done:    make(chan struct{}),
+	}
+	w.files = statFiles(cfg)
+	return w
+}
+
+// Start reloads when a watched file changed, unless ConfigWatchInterval is 0.
+// Reloads on SIGHUP are triggered by the caller through Reload.
+func (w *Watcher) Start() {
+	var tick <-chan time.Time
+	if w.current.ConfigWatchInterval > 0 {
+		ticker := time.NewTicker(w.current.ConfigWatchInterval)
+		tick = ticker.C
+		go func() {
+			<-w.done
+			ticker.Stop()
+		}()
+	}
+
+	go func() {
+		for {
+			select {
+			case <-w.done:
+				return
+			case <-tick:
+				if w.changed() {
+					w.Reload("file changed")
+				}
+			}
+		}
+	}()
+}
+
+// Current returns the configuration last applied
+func (w *Watcher) Current() *Config {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.current
+}
+
+// Stop stops watching
+func (w *Watcher) Stop() {
+	w.once.Do(func() { close(w.done) })
+}
+
+// Reload reads the configuration again and applies it if it is valid
+func (w *Watcher) Reload(reason string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	slog.Info("Reloading configuration", "reason", reason)
+
+	next, err := Reload()
+	if err != nil {
+		// wait for the next change rather than reporting the same errors on every check
+		w.files = statPaths(w.files)
+		slog.Error("Invalid configuration, keeping the current one", "error", err)
+		return
+	}
+	w.files = statFiles(next)
+
+	if err := w.apply(w.current, next); err != nil {
+		slog.Error("Failed to apply configuration, keeping the current one", "error", err)
+		return
+	}
+	w.current = next
+}
+
+// changed reports whether a watched file was modified, created or removed
+func (w *Watcher) changed() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return !reflect.DeepEqual(w.files, statPaths(w.files))
+}
+
+// statFiles returns the state of the files cfg reads
+func statFiles(cfg *Config) map[string]fileState {
+	files := make(map[string]fileState)
+	paths := []string{cfg.ConfigFile, cfg.RoutingRulesFile}
+	for _, ch := range cfg.Channels {
+		paths = append(paths, ch.ClickUpTitleTemplate, ch.ClickUpDescriptionTemplate, ch.TeamsCardTemplate)
+	}
+	for _, path := range paths {
+		if path != "" {
+			files[path] = fileState{}
+		}
+	}
+	return statPaths(files)
+}
+
+// statPaths returns the current state of the files in files, a zero state for missing ones
+func statPaths(files map[string]fileState) map[string]fileState {
+	states := make(map[string]fileState, len(files))
+	for path := range files {
+		var state fileState
+		if info, err := os.Stat(path); err == nil {
+			state = fileState{modTime: info.ModTime(), size: info.Size()}
+		}
+		states[path] = state
+	}
+	return states
+}
+
+// Changed returns the names of the Config fields that differ between a and b
+func Changed(a *Config, b *Config) []string {
+	va, vb := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+
+	var fields []string
+	for i := 0; i < va.NumField(); i++ {
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			fields = append(fields, va.Type().Field(i).Name)
+		}
+	}
+	return fields
+}
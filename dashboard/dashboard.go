@@ -0,0 +1,198 @@
+package dashboard
+
+import (
+	"embed"
+	"html/template"
+	"io"
+	"prisma-webhook/health"
+	"prisma-webhook/store"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed pages/*.html dashboard.css
+var files embed.FS
+
+// pages holds each page parsed together with the shared layout
+var pages = map[string]*template.Template{}
+
+func init() {
+	css, err := files.ReadFile("dashboard.css")
+	if err != nil {
+		panic(err)
+	}
+
+	funcs := template.FuncMap{
+		"css":   func() template.CSS { return template.CSS(css) },
+		"time":  formatTime,
+		"ints":  joinInts,
+		"lower": strings.ToLower,
+	}
+	for _, page := range []string{"overview", "failed", "config"} {
+		pages[page] = template.Must(template.New(page).Funcs(funcs).ParseFS(files, "pages/layout.html", "pages/"+page+".html"))
+	}
+}
+
+// Page holds what the layout shows on every page
+type Page struct {
+	Title string
+
+	// Notice and Problem are shown above the content, e.g. after a retry
+	Notice  string
+	Problem string
+
+	GeneratedAt time.Time
+}
+
+// Bar is one bar of a chart, Percent being its length relative to the longest
+type Bar struct {
+	Label   string
+	Count   int
+	Percent int
+}
+
+// AlertRow is a received alert with the tickets created for it
+type AlertRow struct {
+	*store.AlertHistory
+	Tickets map[string]*store.TicketRef
+}
+
+// Overview is the dashboard's start page: recent alerts and their distribution
+type Overview struct {
+	Page
+
+	// Days is the period shown, one of Periods
+	Days    int
+	Periods []int
+
+	Total           int
+	Filtered        int
+	PendingFailures int
+	QueueDepth      int
+
+	// Delivery attempts in the period by outcome
+	Succeeded int
+	Failed    int
+
+	Severities []Bar
+	Accounts   []Bar
+	Recent     []AlertRow
+}
+
+// Failed lists the failed deliveries waiting in the dead-letter queue
+type Failed struct {
+	Page
+
+	DeadLetters []*store.DeadLetter
+}
+
+// Config shows the running configuration and the state of the channels and dependencies
+type Config struct {
+	Page
+
+	Ready    bool
+	Checks   []Check
+	Channels []ChannelStatus
+	Rules    int
+	Settings []Setting
+}
+
+// Check is the last readiness result of one dependency
+type Check struct {
+	Name string
+	health.Result
+}
+
+// ChannelStatus summarizes a configured channel without revealing its webhook URLs
+type ChannelStatus struct {
+	Name             string
+	ClickUpListID    string
+	ClickUpAssignees []int
+	CustomTemplates  bool
+	Teams            bool
+	Slack            bool
+	Filter           string
+}
+
+// Setting is one configuration value shown on the config page
+type Setting struct {
+	Name  string
+	Value string
+}
+
+// severityOrder lists the Prisma Cloud severities from most to least severe
+var severityOrder = []string{"critical", "high", "medium", "low", "informational"}
+
+// Render writes the named page ("overview", "failed" or "config") with data
+func Render(w io.Writer, page string, data any) error {
+	return pages[page].ExecuteTemplate(w, "layout", data)
+}
+
+// SeverityBars charts counts by severity, from most to least severe, followed by
+// any unknown severities
+func SeverityBars(counts map[string]int) []Bar {
+	rank := make(map[string]int, len(severityOrder))
+	for i, s := range severityOrder {
+		rank[s] = i
+	}
+
+	bars := bars(counts)
+	sort.SliceStable(bars, func(i, j int) bool {
+		ri, ok := rank[bars[i].Label]
+		if !ok {
+			ri = len(severityOrder)
+		}
+		rj, ok := rank[bars[j].Label]
+		if !ok {
+			rj = len(severityOrder)
+		}
+		return ri < rj
+	})
+	return bars
+}
+
+// TopBars charts the limit largest counts, largest first
+func TopBars(counts map[string]int, limit int) []Bar {
+	bars := bars(counts)
+	if len(bars) > limit {
+		bars = bars[:limit]
+	}
+	return bars
+}
+
+// bars turns counts into bars sorted by count, then label
+func bars(counts map[string]int) []Bar {
+	largest := 0
+	for _, n := range counts {
+		largest = max(largest, n)
+	}
+
+	list := make([]Bar, 0, len(counts))
+	for label, n := range counts {
+		list = append(list, Bar{Label: label, Count: n, Percent: n * 100 / max(largest, 1)})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].Label < list[j].Label
+	})
+	return list
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+func joinInts(list []int) string {
+	s := make([]string, len(list))
+	for i, n := range list {
+		s[i] = strconv.Itoa(n)
+	}
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+
+	"prisma-webhook/middleware"
+	"prisma-webhook/queue"
+	"prisma-webhook/services"
+	"prisma-webhook/store"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type AdminHandler struct {
+	queue     *queue.Queue
+	store     *store.Store
+	allowlist *middleware.Allowlist
+}
+
+func NewAdminHandler(
+	queue *queue.Queue,
+	store *store.Store,
+	allowlist *middleware.Allowlist,
+) *AdminHandler {
+	return &AdminHandler{
+		queue:     queue,
+		store:     store,
+		allowlist: allowlist,
+	}
+}
+
+// HandleListDeadLetters lists failed deliveries, pending ones by default.
+// Use ?status=replayed or ?status=all to see the others.
+func (h *AdminHandler) HandleListDeadLetters(c *fiber.Ctx) error {
+	status := c.Query("status", store.DeadLetterPending)
+	if status == "all" {
+		status = ""
+	}
+
+	dls, err := h.store.ListDeadLetters(status)
+	if err != nil {
+		slog.ErrorContext(c.UserContext(), "Failed to list dead letters", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list dead letters",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"count":        len(dls),
+		"dead_letters": dls,
+	})
+}
+
+// HandleGetDeadLetter returns a single failed delivery including its payload
+func (h *AdminHandler) HandleGetDeadLetter(c *fiber.Ctx) error {
+	dl, err := h.loadDeadLetter(c)
+	if dl == nil {
+		return err
+	}
+	return c.JSON(dl)
+}
+
+// HandleReplayDeadLetter queues a failed delivery for processing again
+func (h *AdminHandler) HandleReplayDeadLetter(c *fiber.Ctx) error {
+	dl, err := h.loadDeadLetter(c)
+	if dl == nil {
+		return err
+	}
+
+	job, err := h.replay(c.UserContext(), dl)
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": "Failed to queue replay: " + err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"status":         "queued",
+		"dead_letter_id": dl.ID,
+		"job_id":         job.ID,
+	})
+}
+
+// HandleReplayAllDeadLetters queues every pending failed delivery for processing again
+func (h *AdminHandler) HandleReplayAllDeadLetters(c *fiber.Ctx) error {
+	dls, err := h.store.ListDeadLetters(store.DeadLetterPending)
+	if err != nil {
+		slog.ErrorContext(c.UserContext(), "Failed to list dead letters", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list dead letters",
+		})
+	}
+
+	var jobIDs []string
+	var errors []string
+	for _, dl := range dls {
+		job, err := h.replay(c.UserContext(), dl)
+		if err != nil {
+			errors = append(errors, dl.ID+": "+err.Error())
+			continue
+		}
+		jobIDs = append(jobIDs, job.ID)
+	}
+
+	response := fiber.Map{
+		"replayed": len(jobIDs),
+		"job_ids":  jobIDs,
+	}
+	if len(errors) > 0 {
+		response["errors"] = errors
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(response)
+}
+
+// HandleDeleteDeadLetter discards a failed delivery
+func (h *AdminHandler) HandleDeleteDeadLetter(c *fiber.Ctx) error {
+	dl, err := h.loadDeadLetter(c)
+	if dl == nil {
+		return err
+	}
+
+	if err := h.store.DeleteDeadLetter(dl.ID); err != nil {
+		slog.ErrorContext(c.UserContext(), "Failed to delete dead letter", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete dead letter",
+		})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// HandleGetAllowlist returns the static, loaded and effective IP allowlist
+func (h *AdminHandler) HandleGetAllowlist(c *fiber.Ctx) error {
+	return c.JSON(h.allowlist.Status())
+}
+
+// HandleRefreshAllowlist reloads the allowlist ranges from their source now
+func (h *AdminHandler) HandleRefreshAllowlist(c *fiber.Ctx) error {
+	if err := h.allowlist.Refresh(); err != nil {
+		slog.ErrorContext(c.UserContext(), "Failed to refresh IP allowlist", "error", err)
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"error":     "Failed to refresh allowlist, keeping last known ranges: " + err.Error(),
+			"allowlist": h.allowlist.Status(),
+		})
+	}
+
+	return c.JSON(h.allowlist.Status())
+}
+
+// loadDeadLetter fetches the dead letter named by the :id route param.
+// When it returns nil, the error response has already been written.
+func (h *AdminHandler) loadDeadLetter(c *fiber.Ctx) (*store.DeadLetter, error) {
+	dl, err := h.store.GetDeadLetter(c.Params("id"))
+	if err != nil {
+		slog.ErrorContext(c.UserContext(), "Failed to load dead letter", "error", err)
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load dead letter",
+		})
+	}
+
+	if dl == nil {
+		return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Dead letter not found",
+		})
+	}
+
+	return dl, nil
+}
+
+// replay queues the dead letter's payload as a new job and marks it replayed. For a
+// failed notification, the alert's tickets exist already, so the job only sends it.
+func (h *AdminHandler) replay(ctx context.Context, dl *store.DeadLetter) (*store.Job, error) {
+	if dl.Stage == services.StageNotify && dl.AlertID != "" {
+		if err := h.store.RetryNotification(dl.AlertID, dl.Sink); err != nil {
+			return nil, err
+		}
+	}
+
+	job, err := h.queue.Enqueue(ctx, dl.XType, dl.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	dl.Status = store.DeadLetterReplayed
+	dl.ReplayJobID = job.ID
+	if err := h.store.SaveDeadLetter(dl); err != nil {
+		slog.WarnContext(ctx, "Failed to mark dead letter replayed", "deadLetterId", dl.ID, "error", err)
+	}
+
+	slog.InfoContext(ctx, "Replaying dead letter", "deadLetterId", dl.ID, "jobId", job.ID, "alertId", dl.AlertID, "sink", dl.Sink)
+	return job, nil
+}
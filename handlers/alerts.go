@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"log/slog"
+	"strings"
+	"time"
+
+	"prisma-webhook/store"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Default and maximum page sizes of the alert list
+const (
+	defaultAlertsLimit = 100
+	maxAlertsLimit     = 1000
+)
+
+// AlertsHandler serves the admin API for auditing what happened to received alerts
+type AlertsHandler struct {
+	store *store.Store
+}
+
+func NewAlertsHandler(store *store.Store) *AlertsHandler {
+	return &AlertsHandler{store: store}
+}
+
+// alertFilter selects alert histories by the query parameters severity, account,
+// policy, status, channel, from and to. Lists are comma-separated, accounts and
+// policies match by ID or name, and from/to bound the last receipt time.
+type alertFilter struct {
+	severities []string
+	accounts   []string
+	policies   []string
+	statuses   []string
+	channels   []string
+	from, to   time.Time
+}
+
+// HandleListAlerts lists received alerts, most recently received first.
+// Use ?limit= and ?offset= to page through them.
+func (h *AlertsHandler) HandleListAlerts(c *fiber.Ctx) error {
+	filter, err := parseAlertFilter(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	limit := c.QueryInt("limit", defaultAlertsLimit)
+	offset := c.QueryInt("offset", 0)
+	if limit <= 0 || limit > maxAlertsLimit || offset < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "limit must be between 1 and 1000 and offset must not be negative",
+		})
+	}
+
+	alerts, err := h.store.ListAlertHistory(filter.matches)
+	if err != nil {
+		slog.ErrorContext(c.UserContext(), "Failed to list alerts", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list alerts",
+		})
+	}
+
+	total := len(alerts)
+	page := alerts[min(offset, total):min(offset+limit, total)]
+
+	return c.JSON(fiber.Map{
+		"total":  total,
+		"count":  len(page),
+		"offset": offset,
+		"alerts": page,
+	})
+}
+
+// HandleGetAlert returns one alert with its raw payload, its tickets, every
+// delivery attempt to the sinks and notifiers, and its dead letters
+func (h *AlertsHandler) HandleGetAlert(c *fiber.Ctx) error {
+	ctx := c.UserContext()
+	alertID := c.Params("id")
+
+	history, err := h.store.GetAlertHistory(alertID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load alert history", "alertId", alertID, "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load alert",
+		})
+	}
+	if history == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Alert not found",
+		})
+	}
+
+	rec, err := h.store.GetAlert(alertID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load alert tickets", "alertId", alertID, "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load alert",
+		})
+	}
+	tickets := map[string]*store.TicketRef{}
+	if rec != nil && rec.Tickets != nil {
+		tickets = rec.Tickets
+	}
+
+	deliveries, err := h.store.ListDeliveries(alertID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to list deliveries", "alertId", alertID, "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load alert",
+		})
+	}
+
+	dls, err := h.store.ListDeadLetters("")
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to list dead letters", "alertId", alertID, "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load alert",
+		})
+	}
+	deadLetters := []*store.DeadLetter{}
+	for _, dl := range dls {
+		if dl.AlertID == alertID {
+			deadLetters = append(deadLetters, dl)
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"alert":        history,
+		"tickets":      tickets,
+		"deliveries":   deliveries,
+		"dead_letters": deadLetters,
+	})
+}
+
+// HandleAlertStats counts the alerts matching the same filters as the list by
+// severity, account, policy, status and channel, and their delivery attempts by
+// sink and outcome
+func (h *AlertsHandler) HandleAlertStats(c *fiber.Ctx) error {
+	ctx := c.UserContext()
+	filter, err := parseAlertFilter(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	alerts, err := h.store.ListAlertHistory(filter.matches)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to list alerts", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to count alerts",
+		})
+	}
+
+	bySeverity := map[string]int{}
+	byAccount := map[string]int{}
+	byPolicy := map[string]int{}
+	byStatus := map[string]int{}
+	byChannel := map[string]int{}
+	ids := make(map[string]bool, len(alerts))
+	filtered := 0
+	for _, a := range alerts {
+		ids[a.AlertID] = true
+		bySeverity[orUnknown(strings.ToLower(a.Severity))]++
+		byAccount[orUnknown(a.AccountName)]++
+		byPolicy[orUnknown(a.PolicyName)]++
+		byStatus[orUnknown(strings.ToLower(a.AlertStatus))]++
+		byChannel[a.XType]++
+		if a.Filtered {
+			filtered++
+		}
+	}
+
+	deliveries := map[string]map[string]int{}
+	err = h.store.ForEachDelivery(func(d *store.Delivery) {
+		if !ids[d.AlertID] {
+			return
+		}
+		if deliveries[d.Sink] == nil {
+			deliveries[d.Sink] = map[string]int{}
+		}
+		deliveries[d.Sink][d.Status]++
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to count deliveries", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to count deliveries",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"total":       len(alerts),
+		"filtered":    filtered,
+		"by_severity": bySeverity,
+		"by_account":  byAccount,
+		"by_policy":   byPolicy,
+		"by_status":   byStatus,
+		"by_channel":  byChannel,
+		"deliveries":  deliveries,
+	})
+}
+
+func parseAlertFilter(c *fiber.Ctx) (*alertFilter, error) {
+	f := &alertFilter{
+		severities: queryList(c, "severity"),
+		accounts:   queryList(c, "account"),
+		policies:   queryList(c, "policy"),
+		statuses:   queryList(c, "status"),
+		channels:   queryList(c, "channel"),
+	}
+
+	var err error
+	if f.from, err = parseQueryTime(c, "from"); err != nil {
+		return nil, err
+	}
+	if f.to, err = parseQueryTime(c, "to"); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *alertFilter) matches(a *store.AlertHistory) bool {
+	return matchesAny(f.severities, a.Severity) &&
+		matchesAny(f.accounts, a.AccountID, a.AccountName) &&
+		matchesAny(f.policies, a.PolicyID, a.PolicyName) &&
+		matchesAny(f.statuses, a.AlertStatus) &&
+		matchesAny(f.channels, a.XType) &&
+		(f.from.IsZero() || !a.LastReceivedAt.Before(f.from)) &&
+		(f.to.IsZero() || a.LastReceivedAt.Before(f.to))
+}
+
+// matchesAny reports whether one of the values equals one of the wanted ones,
+// ignoring case. An empty wanted list matches everything.
+func matchesAny(wanted []string, values ...string) bool {
+	if len(wanted) == 0 {
+		return true
+	}
+	for _, w := range wanted {
+		for _, v := range values {
+			if v != "" && strings.EqualFold(w, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// queryList splits a comma-separated query parameter, skipping empty entries
+func queryList(c *fiber.Ctx, key string) []string {
+	var list []string
+	for _, v := range strings.Split(c.Query(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// parseQueryTime parses an RFC 3339 time or a YYYY-MM-DD date (UTC midnight)
+func parseQueryTime(c *fiber.Ctx, key string) (time.Time, error) {
+	v := c.Query(key)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, v); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fiber.NewError(fiber.StatusBadRequest, "Invalid "+key+" time, use RFC 3339 or YYYY-MM-DD")
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
@@ -0,0 +1,338 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"prisma-webhook/channels"
+	"prisma-webhook/config"
+	"prisma-webhook/dashboard"
+	"prisma-webhook/health"
+	"prisma-webhook/queue"
+	"prisma-webhook/routing"
+	"prisma-webhook/store"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Dashboard periods in days, and how many alerts and accounts it shows
+var dashboardPeriods = []int{1, 7, 30, 90}
+
+const (
+	defaultDashboardDays = 7
+	dashboardRecent      = 50
+	dashboardTopAccounts = 10
+)
+
+// DashboardHandler serves the server-rendered dashboard under /admin/dashboard
+type DashboardHandler struct {
+	config   *config.Watcher
+	queue    *queue.Queue
+	store    *store.Store
+	channels *channels.Registry
+	rules    *routing.Engine
+	checker  *health.Checker
+	admin    *AdminHandler
+}
+
+func NewDashboardHandler(
+	watcher *config.Watcher,
+	queue *queue.Queue,
+	store *store.Store,
+	channels *channels.Registry,
+	rules *routing.Engine,
+	checker *health.Checker,
+	admin *AdminHandler,
+) *DashboardHandler {
+	return &DashboardHandler{
+		config:   watcher,
+		queue:    queue,
+		store:    store,
+		channels: channels,
+		rules:    rules,
+		checker:  checker,
+		admin:    admin,
+	}
+}
+
+// HandleOverview shows the alerts of the last ?days= days by severity and account,
+// the most recent ones with their tickets, and delivery counts
+func (h *DashboardHandler) HandleOverview(c *fiber.Ctx) error {
+	days := c.QueryInt("days", defaultDashboardDays)
+	if !slices.Contains(dashboardPeriods, days) {
+		days = defaultDashboardDays
+	}
+	since := time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+
+	alerts, err := h.store.ListAlertHistory(func(a *store.AlertHistory) bool {
+		return !a.LastReceivedAt.Before(since)
+	})
+	if err != nil {
+		return h.fail(c, "Failed to list alerts", err)
+	}
+
+	page := &dashboard.Overview{
+		Page:       h.page("Overview"),
+		Days:       days,
+		Periods:    dashboardPeriods,
+		Total:      len(alerts),
+		QueueDepth: h.queue.Depth(),
+	}
+
+	bySeverity := map[string]int{}
+	byAccount := map[string]int{}
+	for _, a := range alerts {
+		bySeverity[orUnknown(strings.ToLower(a.Severity))]++
+		byAccount[orUnknown(a.AccountName)]++
+		if a.Filtered {
+			page.Filtered++
+		}
+	}
+	page.Severities = dashboard.SeverityBars(bySeverity)
+	page.Accounts = dashboard.TopBars(byAccount, dashboardTopAccounts)
+
+	for _, a := range alerts[:min(len(alerts), dashboardRecent)] {
+		rec, err := h.store.GetAlert(a.AlertID)
+		if err != nil {
+			return h.fail(c, "Failed to load alert tickets", err)
+		}
+		row := dashboard.AlertRow{AlertHistory: a}
+		if rec != nil {
+			row.Tickets = rec.Tickets
+		}
+		page.Recent = append(page.Recent, row)
+	}
+
+	err = h.store.ForEachDelivery(func(d *store.Delivery) {
+		if d.At.Before(since) {
+			return
+		}
+		if d.Status == store.DeliverySucceeded {
+			page.Succeeded++
+		} else {
+			page.Failed++
+		}
+	})
+	if err != nil {
+		return h.fail(c, "Failed to count deliveries", err)
+	}
+
+	dls, err := h.store.ListDeadLetters(store.DeadLetterPending)
+	if err != nil {
+		return h.fail(c, "Failed to list dead letters", err)
+	}
+	page.PendingFailures = len(dls)
+
+	return h.render(c, "overview", page)
+}
+
+// HandleFailed lists the pending dead letters with a retry button each
+func (h *DashboardHandler) HandleFailed(c *fiber.Ctx) error {
+	dls, err := h.store.ListDeadLetters(store.DeadLetterPending)
+	if err != nil {
+		return h.fail(c, "Failed to list dead letters", err)
+	}
+
+	page := &dashboard.Failed{Page: h.page("Failed deliveries"), DeadLetters: dls}
+	if n := c.QueryInt("retried"); n > 0 {
+		page.Notice = fmt.Sprintf("Queued %d failed deliveries for retry.", n)
+	}
+	if n := c.QueryInt("errors"); n > 0 {
+		page.Problem = fmt.Sprintf("%d failed deliveries could not be queued, see the service logs.", n)
+	}
+
+	return h.render(c, "failed", page)
+}
+
+// HandleRetry queues the dead letter named by :id again and returns to the list
+func (h *DashboardHandler) HandleRetry(c *fiber.Ctx) error {
+	if !sameOrigin(c) {
+		return c.Status(fiber.StatusForbidden).SendString("Cross-site request rejected")
+	}
+
+	dl, err := h.store.GetDeadLetter(c.Params("id"))
+	if err != nil || dl == nil || dl.Status != store.DeadLetterPending {
+		if err != nil {
+			slog.ErrorContext(c.UserContext(), "Failed to load dead letter", "error", err)
+		}
+		return c.Redirect("/admin/dashboard/failed?errors=1", fiber.StatusSeeOther)
+	}
+
+	if _, err := h.admin.replay(c.UserContext(), dl); err != nil {
+		slog.ErrorContext(c.UserContext(), "Failed to queue replay", "deadLetterId", dl.ID, "error", err)
+		return c.Redirect("/admin/dashboard/failed?errors=1", fiber.StatusSeeOther)
+	}
+	return c.Redirect("/admin/dashboard/failed?retried=1", fiber.StatusSeeOther)
+}
+
+// HandleRetryAll queues every pending dead letter again and returns to the list
+func (h *DashboardHandler) HandleRetryAll(c *fiber.Ctx) error {
+	if !sameOrigin(c) {
+		return c.Status(fiber.StatusForbidden).SendString("Cross-site request rejected")
+	}
+
+	dls, err := h.store.ListDeadLetters(store.DeadLetterPending)
+	if err != nil {
+		return h.fail(c, "Failed to list dead letters", err)
+	}
+
+	retried, failed := 0, 0
+	for _, dl := range dls {
+		if _, err := h.admin.replay(c.UserContext(), dl); err != nil {
+			slog.ErrorContext(c.UserContext(), "Failed to queue replay", "deadLetterId", dl.ID, "error", err)
+			failed++
+			continue
+		}
+		retried++
+	}
+
+	return c.Redirect(fmt.Sprintf("/admin/dashboard/failed?retried=%d&errors=%d", retried, failed), fiber.StatusSeeOther)
+}
+
+// HandleConfig shows the dependency checks, the channels and the non-secret settings
+func (h *DashboardHandler) HandleConfig(c *fiber.Ctx) error {
+	page := &dashboard.Config{
+		Page:     h.page("Configuration"),
+		Rules:    len(h.rules.Rules()),
+		Settings: h.settings(),
+	}
+
+	ready, results := h.checker.Check(c.UserContext())
+	page.Ready = ready
+	for name, result := range results {
+		page.Checks = append(page.Checks, dashboard.Check{Name: name, Result: result})
+	}
+	sort.Slice(page.Checks, func(i, j int) bool { return page.Checks[i].Name < page.Checks[j].Name })
+
+	cfg := h.config.Current()
+	for _, ch := range h.channels.All() {
+		status := dashboard.ChannelStatus{
+			Name:             ch.Name,
+			ClickUpListID:    ch.ClickUpListID,
+			ClickUpAssignees: ch.ClickUpAssignees,
+			CustomTemplates:  ch.ClickUpTitleTemplate != "" || ch.ClickUpDescriptionTemplate != "" || ch.TeamsCardTemplate != "",
+			Teams:            cfg.TeamsEnabled && ch.TeamsWebhookURL != "",
+			Slack:            cfg.SlackEnabled && ch.SlackWebhookURL != "",
+		}
+		if filter, err := json.Marshal(ch.Filter); err == nil && string(filter) != "{}" {
+			status.Filter = string(filter)
+		}
+		page.Channels = append(page.Channels, status)
+	}
+
+	return h.render(c, "config", page)
+}
+
+// settings lists the configuration last applied, leaving out secrets
+func (h *DashboardHandler) settings() []dashboard.Setting {
+	cfg := h.config.Current()
+
+	var keys []string
+	for _, k := range cfg.APIKeys {
+		key := k.ID
+		var details []string
+		if len(k.Scopes) > 0 {
+			details = append(details, "scopes "+strings.Join(k.Scopes, ", "))
+		}
+		if !k.ExpiresAt.IsZero() {
+			details = append(details, "expires "+k.ExpiresAt.UTC().Format(time.DateOnly))
+		}
+		if k.RateLimit != nil {
+			details = append(details, "rate "+k.RateLimit.String())
+		}
+		if len(details) > 0 {
+			key += " (" + strings.Join(details, "; ") + ")"
+		}
+		keys = append(keys, key)
+	}
+
+	sharePoint := enabled(cfg.SharePointEnabled)
+	if cfg.SharePointEnabled {
+		sharePoint += ", one page per " + cfg.SharePointPageMode
+	}
+
+	return []dashboard.Setting{
+		{Name: "Config file", Value: orNone(cfg.ConfigFile)},
+		{Name: "ClickUp", Value: enabled(cfg.ClickUpEnabled)},
+		{Name: "SharePoint", Value: sharePoint},
+		{Name: "Teams", Value: enabled(cfg.TeamsEnabled)},
+		{Name: "Slack", Value: enabled(cfg.SlackEnabled)},
+		{Name: "Webhook authentication", Value: cfg.WebhookAuthMode},
+		{Name: "API keys", Value: orNone(strings.Join(keys, ", "))},
+		{Name: "Allowed IPs", Value: orNone(strings.Join(cfg.AllowedIPs, ", "))},
+		{Name: "Allowed IPs source", Value: orNone(cfg.AllowedIPsSource)},
+		{Name: "Trusted proxies", Value: orNone(strings.Join(cfg.TrustedProxies, ", "))},
+		{Name: "Rate limits", Value: fmt.Sprintf("webhook %s, general %s, admin %s (%s)", cfg.RateLimitWebhook, cfg.RateLimitGeneral, cfg.RateLimitAdmin, cfg.RateLimitStore)},
+		{Name: "Queue", Value: fmt.Sprintf("%d workers, %d jobs", cfg.QueueWorkers, cfg.QueueSize)},
+		{Name: "Retries", Value: fmt.Sprintf("%d attempts, %s to %s backoff", cfg.RetryMaxAttempts, cfg.RetryBaseDelay, cfg.RetryMaxDelay)},
+		{Name: "Job retention", Value: cfg.JobRetention.String()},
+		{Name: "History retention", Value: retention(cfg.HistoryRetention)},
+		{Name: "Logging", Value: fmt.Sprintf("%s, %s to %s", cfg.LogLevel, cfg.LogFormat, cfg.LogOutput)},
+		{Name: "Metrics", Value: enabled(cfg.MetricsEnabled)},
+		{Name: "Tracing", Value: cfg.TracingExporter},
+		{Name: "Data directory", Value: cfg.DataDir},
+	}
+}
+
+func (h *DashboardHandler) page(title string) dashboard.Page {
+	return dashboard.Page{Title: title, GeneratedAt: time.Now()}
+}
+
+func (h *DashboardHandler) render(c *fiber.Ctx, name string, data any) error {
+	var buf bytes.Buffer
+	if err := dashboard.Render(&buf, name, data); err != nil {
+		return h.fail(c, "Failed to render dashboard", err)
+	}
+
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Type("html", "utf-8")
+	return c.Send(buf.Bytes())
+}
+
+func (h *DashboardHandler) fail(c *fiber.Ctx, msg string, err error) error {
+	slog.ErrorContext(c.UserContext(), msg, "error", err)
+	return c.Status(fiber.StatusInternalServerError).SendString(msg)
+}
+
+// sameOrigin reports whether a browser form post comes from the dashboard itself.
+// The dashboard accepts HTTP Basic credentials, which browsers send along with
+// cross-site requests, so other sites must not be able to trigger retries. Browsers
+// send Origin with every form post, so a post without either header is rejected too.
+func sameOrigin(c *fiber.Ctx) bool {
+	if site := c.Get("Sec-Fetch-Site"); site != "" {
+		return site == "same-origin"
+	}
+	if origin := c.Get(fiber.HeaderOrigin); origin != "" {
+		u, err := url.Parse(origin)
+		return err == nil && u.Host == string(c.Request().Host())
+	}
+	return false
+}
+
+func enabled(on bool) string {
+	if on {
+		return "enabled"
+	}
+	return "disabled"
+}
+
+func retention(d time.Duration) string {
+	if d == 0 {
+		return "kept"
+	}
+	return d.String()
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"log/slog"
+
+	"prisma-webhook/health"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type HealthHandler struct {
+	checker *health.Checker
+}
+
+func NewHealthHandler(checker *health.Checker) *HealthHandler {
+	return &HealthHandler{
+		checker: checker,
+	}
+}
+
+// HandleReady reports whether each dependency is up, answering 503 when one is down.
+// It needs no API key, so errors and durations, which can name ClickUp lists, are
+// left to HandleReadyDetails.
+func (h *HealthHandler) HandleReady(c *fiber.Ctx) error {
+	ready, checks := h.check(c)
+
+	statuses := make(map[string]string, len(checks))
+	for name, check := range checks {
+		statuses[name] = check.Status
+	}
+	return h.respond(c, ready, statuses)
+}
+
+// HandleReadyDetails reports each dependency's last check result with its error,
+// duration and time, for the admin endpoint
+func (h *HealthHandler) HandleReadyDetails(c *fiber.Ctx) error {
+	ready, checks := h.check(c)
+	return h.respond(c, ready, checks)
+}
+
+// check runs the dependency checks and logs the failed ones
+func (h *HealthHandler) check(c *fiber.Ctx) (bool, map[string]health.Result) {
+	ready, checks := h.checker.Check(c.UserContext())
+	if !ready {
+		for name, check := range checks {
+			if check.Status != health.StatusUp {
+				slog.WarnContext(c.UserContext(), "Dependency check failed", "component", name, "error", check.Error)
+			}
+		}
+	}
+	return ready, checks
+}
+
+func (h *HealthHandler) respond(c *fiber.Ctx, ready bool, checks any) error {
+	if !ready {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"status": "not_ready",
+			"checks": checks,
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "ready",
+		"checks": checks,
+	})
+}
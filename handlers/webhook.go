@@ -1,141 +1,132 @@
 package handlers
 
 import (
-	"github.com/gofiber/fiber/v2/log"
+	"errors"
+	"log/slog"
 
+	"prisma-webhook/logging"
+	"prisma-webhook/metrics"
+	"prisma-webhook/middleware"
 	"prisma-webhook/models"
-	"prisma-webhook/services"
-	"strings"
+	"prisma-webhook/queue"
+	"prisma-webhook/store"
 
 	"github.com/gofiber/fiber/v2"
 )
 
 type WebhookHandler struct {
-	clickUpClient *services.ClickUpClient
-	teamsClient   *services.TeamsClient
+	queue *queue.Queue
+	store *store.Store
 }
 
 func NewWebhookHandler(
-	clickUpClient *services.ClickUpClient,
-	teamsClient *services.TeamsClient,
+	queue *queue.Queue,
+	store *store.Store,
 ) *WebhookHandler {
 	return &WebhookHandler{
-		clickUpClient: clickUpClient,
-		teamsClient:   teamsClient,
+		queue: queue,
+		store: store,
 	}
 }
 
-// HandlePrismaWebhook processes incoming Prisma Cloud webhook alerts
+// HandlePrismaWebhook validates incoming Prisma Cloud webhook alerts and queues them for processing
 func (h *WebhookHandler) HandlePrismaWebhook(c *fiber.Ctx) error {
+	channel := middleware.ChannelName(c)
+	ctx := logging.With(c.UserContext(), "xType", channel)
+	c.SetUserContext(ctx)
+
 	// Log the incoming request
-	log.Infof("Received webhook from %s", c.IP())
-	// log.Infof("Payload: %v", string(c.Request().Body()))
-	log.Infof("Received type: %s", c.Get("X-Type"))
-
-	// Parse the request body
-	var alerts []models.CustomPrismaAlert
-	var singleAlert models.CustomPrismaAlert
-
-	// Try to parse as array first
-	if err := c.BodyParser(&alerts); err != nil {
-		// If array parsing fails, try single object
-		if err := c.BodyParser(&singleAlert); err != nil {
-			log.Infof("Failed to parse webhook payload: %v, request: %v", err, string(c.Request().Body()))
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid request payload",
-			})
-		}
-		// If single object parsed successfully, add it to alerts slice
-		alerts = []models.CustomPrismaAlert{singleAlert}
+	slog.InfoContext(ctx, "Received webhook", "clientIp", middleware.ClientIP(c), "keyId", middleware.APIKeyID(c), "bytes", len(c.Body()))
+
+	// Parse the request body (array of alerts or a single alert)
+	alerts, err := models.ParseAlerts(c.Body())
+	if err != nil {
+		// the payload can hold resource names and tags, so only its shape is logged
+		slog.WarnContext(ctx, "Failed to parse webhook payload", "error", err, "payload", logging.RedactPayload(c.Body(), 1024))
+		metrics.InvalidPayloads.Inc()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request payload",
+		})
 	}
 
 	// If no alerts received
 	if len(alerts) == 0 {
-		log.Info("No alerts in webhook payload")
+		slog.InfoContext(ctx, "No alerts in webhook payload")
+		metrics.InvalidPayloads.Inc()
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "No alerts in payload",
 		})
 	}
 
-	log.Infof("Processing %d alert(s)", len(alerts))
-
-	// Create ClickUp task for each alert
-	var createdTasks []string
-	var errors []string
-	var isTestMessage bool
-	var sharepointPages []string
-	var teamsNotifications []string
-
-	for i, alert := range alerts {
-		if strings.HasPrefix(alert.Message, "This is a test message from Prisma Cloud initiated") {
-			isTestMessage = true
-			break
+	for _, alert := range alerts {
+		if alert.IsTestMessage() {
+			metrics.TestMessages.Inc()
+			slog.InfoContext(ctx, "Test webhook received")
+			return c.Status(fiber.StatusOK).JSON(fiber.Map{
+				"message": "Test webhook received",
+			})
 		}
+	}
 
-		log.Infof("Processing alert %d: %s (Severity: %s)", i+1, alert.PolicyName, alert.Severity)
-
-		// Step 1: Create ClickUp task
-		task, err := h.clickUpClient.CreateTask(&alert, c.Get("X-Type"))
-		if err != nil {
-			errMsg := "Failed to create task for alert: " + err.Error()
-			log.Infof("Error for alert %d: %s", i+1, errMsg)
-			errors = append(errors, errMsg)
-			continue
+	// Persist the delivery and let the workers create tasks and notifications
+	job, err := h.queue.Enqueue(ctx, channel, c.Body())
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to queue webhook", "error", err)
+		status := fiber.StatusInternalServerError
+		if errors.Is(err, queue.ErrQueueFull) || errors.Is(err, queue.ErrQueueStopped) {
+			status = fiber.StatusServiceUnavailable
 		}
+		return c.Status(status).JSON(fiber.Map{
+			"error": "Failed to queue webhook, please retry",
+		})
+	}
 
-		log.Infof("Created ClickUp task: %s (ID: %s)", task.Name, task.ID)
-		createdTasks = append(createdTasks, task.ID)
-
-		clickupURL := task.URL
+	slog.InfoContext(ctx, "Queued webhook", "jobId", job.ID, "alerts", len(alerts))
 
-		prismaURL := ""
-		if alert.CallbackUrl != "" {
-			prismaURL = alert.CallbackUrl
-		}
-		// prismaURL := "https://app.id.prismacloud.io/alerts/overview?viewId=default&filters={\"alert.id\":[\"" + alert.AlertID + "\"]}\n"
-
-		// Step 2: Send Teams notification (if enabled)
-		if h.teamsClient.IsEnabled() {
-			err = h.teamsClient.SendTeamsNotificationV2(&alert, clickupURL, prismaURL, c.Get("X-Type"))
-			if err != nil {
-				errMsg := "Failed to send Teams notification: " + err.Error()
-				log.Infof("Warning for alert %d: %s", i+1, errMsg)
-				errors = append(errors, errMsg)
-			} else {
-				log.Infof("Sent Teams notification for alert %d", i+1)
-				teamsNotifications = append(teamsNotifications, "sent")
-			}
-		}
+	metrics.WebhooksReceived.WithLabelValues(channel, middleware.APIKeyID(c)).Inc()
+	for _, alert := range alerts {
+		metrics.ObserveAlert(alert.Severity, alert.CloudType)
 	}
 
-	if isTestMessage {
-		return c.Status(fiber.StatusOK).JSON(fiber.Map{
-			"message": "Test webhook received",
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"status":   "queued",
+		"received": len(alerts),
+		"job_id":   job.ID,
+	})
+}
+
+// HandleGetJob returns the processing status and result of a queued webhook delivery
+func (h *WebhookHandler) HandleGetJob(c *fiber.Ctx) error {
+	job, err := h.store.GetJob(c.Params("id"))
+	if err != nil {
+		slog.ErrorContext(c.UserContext(), "Failed to load job", "jobId", c.Params("id"), "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load job",
 		})
 	}
 
-	// Build response
-	response := fiber.Map{
-		"received":      len(alerts),
-		"tasks_created": len(createdTasks),
-		"task_ids":      createdTasks,
+	if job == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Job not found",
+		})
 	}
 
-	if len(sharepointPages) > 0 {
-		response["sharepoint_pages_created"] = len(sharepointPages)
-		response["sharepoint_urls"] = sharepointPages
+	response := fiber.Map{
+		"job_id":     job.ID,
+		"type":       job.XType,
+		"status":     job.Status,
+		"attempts":   job.Attempts,
+		"created_at": job.CreatedAt,
+		"updated_at": job.UpdatedAt,
 	}
 
-	if len(teamsNotifications) > 0 {
-		response["teams_notifications_sent"] = len(teamsNotifications)
+	if job.Result != nil {
+		response["result"] = job.Result
 	}
 
-	if len(errors) > 0 {
-		response["errors"] = errors
-		response["status"] = "partial_success"
-	} else {
-		response["status"] = "success"
+	if job.Error != "" {
+		response["error"] = job.Error
 	}
 
-	return c.Status(fiber.StatusOK).JSON(response)
+	return c.JSON(response)
 }
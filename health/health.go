@@ -0,0 +1,118 @@
+package health
+
+import (
+	"context"
+	"prisma-webhook/config"
+	"sync"
+	"time"
+)
+
+// Component statuses
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// Check probes one dependency and returns nil when it is usable
+type Check func(ctx context.Context) error
+
+// Result is the outcome of a component's last check
+type Result struct {
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+	CheckedAt  time.Time `json:"checked_at"`
+}
+
+// Checker runs the registered dependency checks and caches each result for a TTL,
+// so frequent readiness probes do not reach the upstream APIs every time
+type Checker struct {
+	ttl     time.Duration
+	timeout time.Duration
+
+	mu         sync.RWMutex
+	components []*component
+}
+
+type component struct {
+	name  string
+	check Check
+
+	// mu is held while the check runs, so concurrent probes share one call
+	mu      sync.Mutex
+	result  Result
+	expires time.Time
+}
+
+func NewChecker(cfg *config.Config) *Checker {
+	return &Checker{
+		ttl:     cfg.ReadyCacheTTL,
+		timeout: cfg.ReadyCheckTimeout,
+	}
+}
+
+// Register adds a dependency check under name
+func (c *Checker) Register(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.components = append(c.components, &component{name: name, check: check})
+}
+
+// Check returns every component's result, running the checks whose cached result
+// expired in parallel. ready is true when all components are up.
+func (c *Checker) Check(ctx context.Context) (ready bool, results map[string]Result) {
+	c.mu.RLock()
+	components := c.components
+	c.mu.RUnlock()
+
+	list := make([]Result, len(components))
+	var wg sync.WaitGroup
+	for i, comp := range components {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			list[i] = c.result(ctx, comp)
+		}()
+	}
+	wg.Wait()
+
+	ready = true
+	results = make(map[string]Result, len(components))
+	for i, comp := range components {
+		results[comp.name] = list[i]
+		if list[i].Status != StatusUp {
+			ready = false
+		}
+	}
+	return ready, results
+}
+
+// result returns the cached result of comp, or runs its check when it expired
+func (c *Checker) result(ctx context.Context, comp *component) Result {
+	comp.mu.Lock()
+	defer comp.mu.Unlock()
+
+	if time.Now().Before(comp.expires) {
+		return comp.result
+	}
+
+	// a probe that disconnects must not leave a cancelled check in the cache
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := comp.check(ctx)
+
+	comp.result = Result{
+		Status:     StatusUp,
+		DurationMs: time.Since(start).Milliseconds(),
+		CheckedAt:  start.UTC(),
+	}
+	if err != nil {
+		comp.result.Status = StatusDown
+		comp.result.Error = err.Error()
+	}
+	comp.expires = start.Add(c.ttl)
+	return comp.result
+}
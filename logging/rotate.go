@@ -0,0 +1,277 @@
+package logging
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is the timestamp in rotated file names; it sorts chronologically
+const backupTimeFormat = "20060102T150405.000"
+
+// FileOptions configures a log file and its rotation. Zero values turn the
+// respective rotation or retention rule off.
+type FileOptions struct {
+	Path string
+
+	// MaxSize rotates the file before it grows past this many bytes
+	MaxSize int64
+
+	// RotateEvery rotates the file when the current period (e.g. 24h, aligned to UTC
+	// midnight) ends
+	RotateEvery time.Duration
+
+	// MaxBackups and MaxAge limit the rotated files that are kept
+	MaxBackups int
+	MaxAge     time.Duration
+
+	// Compress gzips rotated files
+	Compress bool
+}
+
+// RotatingFile is an io.Writer appending to a log file, which it rotates by size
+// and time. Rotated files are renamed to <name>-<timestamp><ext>, then compressed
+// and pruned in the background.
+type RotatingFile struct {
+	opts FileOptions
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+
+	cleanupMu sync.Mutex
+}
+
+// OpenFile opens (or creates) the log file, including its directory
+func OpenFile(opts FileOptions) (*RotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(opts.Path), 0750); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	f := &RotatingFile{opts: opts}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	go f.cleanup()
+	return f, nil
+}
+
+// Write implements io.Writer, rotating first when p would not fit or the period ended
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	if f.shouldRotate(len(p)) {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Reopen closes the file and opens the configured path again, for external tools
+// such as logrotate that move the file away and then signal the service
+func (f *RotatingFile) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.close(); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+// Close closes the file. A later Write opens it again.
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.close()
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.opts.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+
+	f.file = file
+	f.size = info.Size()
+	f.openedAt = time.Now()
+	if info.Size() > 0 {
+		// an existing file belongs to the period it was last written in
+		f.openedAt = info.ModTime()
+	}
+	return nil
+}
+
+func (f *RotatingFile) close() error {
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func (f *RotatingFile) shouldRotate(next int) bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.opts.MaxSize > 0 && f.size+int64(next) > f.opts.MaxSize {
+		return true
+	}
+	if f.opts.RotateEvery > 0 {
+		now := time.Now().UTC()
+		return now.Truncate(f.opts.RotateEvery).After(f.openedAt.UTC().Truncate(f.opts.RotateEvery))
+	}
+	return false
+}
+
+// rotate renames the current file to a timestamped backup and starts a new one
+func (f *RotatingFile) rotate() error {
+	if err := f.close(); err != nil {
+		return err
+	}
+
+	ext := filepath.Ext(f.opts.Path)
+	backup := strings.TrimSuffix(f.opts.Path, ext) + "-" + time.Now().UTC().Format(backupTimeFormat) + ext
+	if err := os.Rename(f.opts.Path, backup); err != nil {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+
+	if err := f.open(); err != nil {
+		return err
+	}
+
+	go f.cleanup()
+	return nil
+}
+
+// cleanup compresses rotated files and removes those beyond MaxBackups or MaxAge.
+// Errors are reported on stderr, as the log itself may be what is failing.
+func (f *RotatingFile) cleanup() {
+	f.cleanupMu.Lock()
+	defer f.cleanupMu.Unlock()
+
+	backups, err := f.backups()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "log cleanup: %v\n", err)
+		return
+	}
+
+	// newest first
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+
+	cutoff := time.Now().Add(-f.opts.MaxAge)
+	for i, path := range backups {
+		expired := f.opts.MaxBackups > 0 && i >= f.opts.MaxBackups
+		if !expired && f.opts.MaxAge > 0 {
+			if info, err := os.Stat(path); err == nil && info.ModTime().Before(cutoff) {
+				expired = true
+			}
+		}
+
+		if expired {
+			if err := os.Remove(path); err != nil {
+				fmt.Fprintf(os.Stderr, "log cleanup: %v\n", err)
+			}
+			continue
+		}
+
+		if f.opts.Compress && !strings.HasSuffix(path, ".gz") {
+			if err := compressFile(path); err != nil {
+				fmt.Fprintf(os.Stderr, "log cleanup: %v\n", err)
+			}
+		}
+	}
+}
+
+// backups lists the rotated files, compressed or not
+func (f *RotatingFile) backups() ([]string, error) {
+	ext := filepath.Ext(f.opts.Path)
+	prefix := strings.TrimSuffix(filepath.Base(f.opts.Path), ext) + "-"
+
+	entries, err := os.ReadDir(filepath.Dir(f.opts.Path))
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		stamp := strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ext)
+		stamp = strings.TrimPrefix(stamp, prefix)
+		if _, err := time.Parse(backupTimeFormat, stamp); err != nil {
+			continue
+		}
+		backups = append(backups, filepath.Join(filepath.Dir(f.opts.Path), name))
+	}
+	return backups, nil
+}
+
+// compressFile gzips path to path.gz and removes the original
+func compressFile(path string) (err error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(path + ".gz")
+		}
+	}()
+
+	gz := gzip.NewWriter(dst)
+	if _, err = io.Copy(gz, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err = gz.Close(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err = dst.Close(); err != nil {
+		return err
+	}
+
+	src.Close()
+	return os.Remove(path)
+}
+
+// Path returns the path of the log file
+func (f *RotatingFile) Path() string {
+	return f.opts.Path
+}
@@ -1,44 +1,178 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"io"
+	"log/slog"
 	"os"
+	"os/signal"
+	"prisma-webhook/channels"
 	"prisma-webhook/config"
 	"prisma-webhook/handlers"
+	"prisma-webhook/health"
+	"prisma-webhook/logging"
+	"prisma-webhook/metrics"
 	"prisma-webhook/middleware"
+	"prisma-webhook/queue"
+	"prisma-webhook/routing"
 	"prisma-webhook/services"
+	"prisma-webhook/store"
+	"prisma-webhook/tracing"
+	"syscall"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/log"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
 	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
 	// Load configuration
 	cfg := config.Load()
 
-	// default logger
-	file, err := os.OpenFile("/logs/webhook.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	// SIGHUP reopens the log file and reloads the configuration, see handleSIGHUP.
+	// Subscribed before anything else, as an unhandled SIGHUP ends the process.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	// default logger, writing to stdout and/or a rotated log file
+	var logOutput io.Writer = os.Stdout
+	var logFile *logging.RotatingFile
+	if cfg.LogOutput != "stdout" {
+		var err error
+		logFile, err = logging.OpenFile(logging.FileOptions{
+			Path:        cfg.LogFile,
+			MaxSize:     cfg.LogMaxSize,
+			RotateEvery: cfg.LogRotateEvery,
+			MaxBackups:  cfg.LogMaxBackups,
+			MaxAge:      cfg.LogMaxAge,
+			Compress:    cfg.LogCompress,
+		})
+		if err != nil {
+			fatal("Failed to open log file", err)
+		}
+		defer logFile.Close()
+
+		logOutput = logFile
+		if cfg.LogOutput == "both" {
+			logOutput = io.MultiWriter(os.Stdout, logFile)
+		}
+	}
+	logging.Setup(logOutput, cfg.LogFormat, cfg.LogLevel)
+
+	// Tracing, exported over OTLP or to stdout/a file when configured
+	shutdownTracing, err := tracing.Init(cfg)
 	if err != nil {
-		log.Fatal(err)
+		fatal("Failed to set up tracing", err)
 	}
-	iw := io.MultiWriter(os.Stdout, file)
-	log.SetOutput(iw)
-	defer file.Close()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Error("Failed to flush traces", "error", err)
+		}
+	}()
+
+	// Open persistent store
+	db, err := store.Open(cfg.DataDir)
+	if err != nil {
+		fatal("Failed to open store", err)
+	}
+	defer db.Close()
 
 	// Initialize services
-	clickUpClient := services.NewClickUpClient(cfg)
-	teamsClient := services.NewTeamsClient(cfg)
+	rules, err := loadRouting(cfg)
+	if err != nil {
+		fatal("Failed to load routing rules", err)
+	}
+
+	// Channels with their ClickUp task and Teams card templates
+	channelRegistry, err := channels.Load(cfg)
+	if err != nil {
+		fatal("Failed to load channel templates", err)
+	}
+
+	clickUpClient := services.NewClickUpClient(cfg, rules, channelRegistry)
+	teamsClient := services.NewTeamsClient(rules, channelRegistry)
+
+	// Ticket sinks and notifiers the alerts fan out to
+	var sinks []services.TicketSink
+	if cfg.ClickUpEnabled {
+		sinks = append(sinks, clickUpClient)
+	}
+	if cfg.SharePointEnabled {
+		sinks = append(sinks, services.NewSharePointClient(cfg))
+	}
+
+	var notifiers []services.Notifier
+	if cfg.TeamsEnabled {
+		notifiers = append(notifiers, teamsClient)
+	}
+	if cfg.SlackEnabled {
+		notifiers = append(notifiers, services.NewSlackClient(rules, channelRegistry))
+	}
+
+	// Routing rules, channels and ClickUp statuses follow the configuration without
+	// a restart: reloaded on SIGHUP or when the config, rules or template files change
+	watcher := config.NewWatcher(cfg, func(current *config.Config, next *config.Config) error {
+		return applyReload(current, next, rules, channelRegistry, clickUpClient)
+	})
+	watcher.Start()
+	defer watcher.Stop()
+	go handleSIGHUP(hup, logFile, watcher)
+
+	processor := services.NewAlertProcessor(channelRegistry, sinks, notifiers, db, services.NewRetryPolicy(cfg))
+
+	// Start processing queue
+	jobQueue := queue.NewQueue(cfg, db, processor)
+	if err := jobQueue.Start(); err != nil {
+		fatal("Failed to start job queue", err)
+	}
+
+	// IP allowlist, refreshed from ALLOWED_IPS_SOURCE if set
+	allowlist := middleware.NewAllowlist(cfg.AllowedIPs, cfg.AllowedIPsSource)
+	if err := allowlist.Refresh(); err != nil {
+		slog.Error("Failed to load IP allowlist ranges, using ALLOWED_IPS only", "source", cfg.AllowedIPsSource, "error", err)
+	}
+	allowlist.Start(cfg.AllowedIPsRefresh)
+	defer allowlist.Stop()
+
+	// Rate limits, counted in memory or in Redis
+	rateLimitStore, err := middleware.NewRateLimitStore(cfg)
+	if err != nil {
+		fatal("Failed to set up rate limit store", err)
+	}
+	rateLimiter := middleware.NewRateLimiter(rateLimitStore, cfg.APIKeys)
 
 	// Initialize handlers
-	webhookHandler := handlers.NewWebhookHandler(clickUpClient, teamsClient)
+	webhookHandler := handlers.NewWebhookHandler(jobQueue, db)
+	adminHandler := handlers.NewAdminHandler(jobQueue, db, allowlist)
+	alertsHandler := handlers.NewAlertsHandler(db)
+
+	// Dependency checks behind /ready, cached for READY_CACHE_TTL
+	checker := health.NewChecker(cfg)
+	checker.Register("store", db.Ping)
+	if cfg.ClickUpEnabled {
+		checker.Register("clickup", clickUpClient.CheckHealth)
+	}
+	if cfg.TeamsEnabled {
+		checker.Register("teams", teamsClient.CheckHealth)
+	}
+	healthHandler := handlers.NewHealthHandler(checker)
+	dashboardHandler := handlers.NewDashboardHandler(watcher, jobQueue, db, channelRegistry, rules, checker, adminHandler)
 
 	// Create Fiber app
 	app := fiber.New(fiber.Config{
-		AppName: "Prisma Cloud to ClickUp Webhook",
+		AppName:               "Prisma Cloud to ClickUp Webhook",
+		DisableStartupMessage: true,
 	})
 
+	// request IDs and access logs first, so panics caught by recover are logged too
+	app.Use(middleware.RequestLog())
 	app.Use(recover.New())
+	app.Use(middleware.RealIP(cfg.TrustedProxies))
 
 	// Routes
 	// Health check - no rate limit for monitoring
@@ -48,8 +182,18 @@ func main() {
 		})
 	})
 
+	// Readiness check - ClickUp, Teams and the store, unauthenticated like /health.
+	// Only the check statuses are shown here, the details are under /admin/ready.
+	app.Get("/ready", healthHandler.HandleReady)
+
+	// Prometheus metrics - unauthenticated like /health, for the scraper
+	if cfg.MetricsEnabled {
+		metrics.RegisterQueueDepth(jobQueue.Depth)
+		app.Get("/metrics", adaptor.HTTPHandler(promhttp.Handler()))
+	}
+
 	// Root endpoint - with rate limit
-	app.Get("/", middleware.GeneralRateLimit(), func(c *fiber.Ctx) error {
+	app.Get("/", rateLimiter.Limit("root", cfg.RateLimitGeneral), func(c *fiber.Ctx) error {
 		return c.JSON(fiber.Map{
 			"service": "Prisma Cloud to ClickUp Webhook",
 			"version": "1.3.0",
@@ -57,30 +201,180 @@ func main() {
 		})
 	})
 
-	// Webhook endpoint - with IP allowlist, API key auth, and rate limit
-	app.Post("/webhook",
-		middleware.IPAllowlist(cfg.AllowedIPs),
-		middleware.APIKeyAuth(cfg.WebhookAPIKey),
-		middleware.WebhookRateLimit(),
-		func(c *fiber.Ctx) error {
-			xType := c.Get("X-Type")
-
-			// choose handler based on header
-			if xType != "alerta" && xType != "mandatory" {
-				log.Debugf("Unknown X-Type: %s", xType)
-				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-					"error": "Invalid or missing type header",
-				})
-			}
-
-			return c.Next()
-		},
+	// Webhook endpoints - with IP allowlist, API key or signature auth, and rate limit.
+	// The channel is named by the path or, on /webhook, by the X-Type header.
+	webhook := []fiber.Handler{
+		middleware.Tracing(),
+		middleware.IPAllowlist(allowlist),
+		middleware.WebhookAuth(cfg.WebhookAuthMode, cfg.APIKeys, cfg.WebhookSigningSecret, cfg.WebhookSignatureTolerance),
+		rateLimiter.Limit("webhook", cfg.RateLimitWebhook),
+		middleware.Channel(channelRegistry.Has),
 		webhookHandler.HandlePrismaWebhook,
+	}
+	app.Post("/webhook", webhook...)
+	app.Post("/webhook/:channel", webhook...)
+
+	// Job status endpoint - with IP allowlist and API key auth
+	app.Get("/jobs/:id",
+		middleware.Tracing(),
+		middleware.IPAllowlist(allowlist),
+		middleware.APIKeyAuth(cfg.APIKeys, nil),
+		rateLimiter.Limit("jobs", cfg.RateLimitGeneral),
+		webhookHandler.HandleGetJob,
+	)
+
+	// Admin endpoints - with IP allowlist, API key auth requiring the admin scope and
+	// rate limit. Only the dashboard takes the key as HTTP Basic password too.
+	adminScope := middleware.StaticScope(middleware.ScopeAdmin)
+	adminAuth := middleware.APIKeyAuth(cfg.APIKeys, adminScope)
+	adminLimit := rateLimiter.Limit("admin", cfg.RateLimitAdmin)
+	admin := app.Group("/admin",
+		middleware.Tracing(),
+		middleware.IPAllowlist(allowlist),
 	)
 
+	dlq := admin.Group("/dlq", adminAuth, adminLimit)
+	dlq.Get("", adminHandler.HandleListDeadLetters)
+	dlq.Post("/replay", adminHandler.HandleReplayAllDeadLetters)
+	dlq.Get("/:id", adminHandler.HandleGetDeadLetter)
+	dlq.Post("/:id/replay", adminHandler.HandleReplayDeadLetter)
+	dlq.Delete("/:id", adminHandler.HandleDeleteDeadLetter)
+
+	admin.Get("/ready", adminAuth, adminLimit, healthHandler.HandleReadyDetails)
+
+	allowlistAdmin := admin.Group("/allowlist", adminAuth, adminLimit)
+	allowlistAdmin.Get("", adminHandler.HandleGetAllowlist)
+	allowlistAdmin.Post("/refresh", adminHandler.HandleRefreshAllowlist)
+
+	api := admin.Group("/api", adminAuth, adminLimit)
+	api.Get("/alerts", alertsHandler.HandleListAlerts)
+	api.Get("/alerts/:id", alertsHandler.HandleGetAlert)
+	api.Get("/stats", alertsHandler.HandleAlertStats)
+
+	dashboard := admin.Group("/dashboard", middleware.BrowserAPIKeyAuth(cfg.APIKeys, adminScope), adminLimit)
+	dashboard.Get("", dashboardHandler.HandleOverview)
+	dashboard.Get("/failed", dashboardHandler.HandleFailed)
+	dashboard.Post("/failed/retry", dashboardHandler.HandleRetryAll)
+	dashboard.Post("/failed/:id/retry", dashboardHandler.HandleRetry)
+	dashboard.Get("/config", dashboardHandler.HandleConfig)
+
 	// Start server
-	log.Debugf("Starting server on port %s", cfg.Port)
-	if err := app.Listen(":" + cfg.Port); err != nil {
-		log.Fatal(err)
+	go func() {
+		slog.Info("Starting server", "port", cfg.Port)
+		if err := app.Listen(":" + cfg.Port); err != nil {
+			fatal("Server stopped", err)
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-quit
+
+	shutdown(cfg, app, jobQueue, db, sig)
+}
+
+// shutdown stops taking webhooks, then waits for the requests in flight and the
+// queued jobs until cfg.ShutdownTimeout. Jobs left unfinished are reported; they
+// stay persisted and are resumed on the next start.
+func shutdown(cfg *config.Config, app *fiber.App, jobQueue *queue.Queue, db *store.Store, sig os.Signal) {
+	slog.Info("Shutting down", "signal", sig.String(), "timeout", cfg.ShutdownTimeout)
+	start := time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err := app.ShutdownWithContext(ctx); err != nil {
+		slog.Warn("Requests still open at the shutdown deadline", "error", err)
+	}
+
+	if err := jobQueue.Stop(ctx); err != nil {
+		slog.Warn("Jobs still running at the shutdown deadline", "error", err)
+	}
+
+	pending, err := db.PendingJobs()
+	if err != nil {
+		slog.Error("Failed to list unfinished jobs", "error", err)
+	}
+	for _, job := range pending {
+		slog.Warn("Job left unfinished, resuming on next start", "jobId", job.ID, "xType", job.XType, "status", job.Status, "requestId", job.RequestID)
 	}
+
+	slog.Info("Shutdown complete", "unfinishedJobs", len(pending), "duration", time.Since(start))
+}
+
+// handleSIGHUP serves every SIGHUP in a fixed order: first the log file is reopened,
+// as logrotate sends SIGHUP after moving it away, then the configuration is reloaded,
+// so that the reload is logged to the new file. logFile is nil when logging to stdout.
+func handleSIGHUP(hup <-chan os.Signal, logFile *logging.RotatingFile, watcher *config.Watcher) {
+	for sig := range hup {
+		if logFile != nil {
+			if err := logFile.Reopen(); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to reopen log file: %v\n", err)
+			} else {
+				slog.Info("Reopened log file", "path", logFile.Path())
+			}
+		}
+		watcher.Reload(sig.String())
+	}
+}
+
+// loadRouting builds the routing rules from the config file or ROUTING_RULES_FILE
+func loadRouting(cfg *config.Config) (*routing.Engine, error) {
+	if len(cfg.RoutingRules) > 0 {
+		return routing.NewEngine(cfg.RoutingRules)
+	}
+	return routing.Load(cfg.RoutingRulesFile)
+}
+
+// reloadable are the Config fields applyReload puts into effect; changes to the
+// others are only logged, as they need a restart
+var reloadable = map[string]bool{
+	"RoutingRulesFile":       true,
+	"RoutingRules":           true,
+	"Channels":               true,
+	"ClickUpResolvedStatus":  true,
+	"ClickUpDismissedStatus": true,
+	"ClickUpSnoozedStatus":   true,
+	"ClickUpReopenStatus":    true,
+}
+
+// applyReload loads the routing rules and channel templates of next and, once all of
+// them are valid, switches the running services over from current. The files are read
+// again even if their paths did not change, as their content may have. Settings that
+// need a restart are compared with current, so each change is reported once.
+func applyReload(
+	current *config.Config,
+	next *config.Config,
+	rules *routing.Engine,
+	channelRegistry *channels.Registry,
+	clickUp *services.ClickUpClient,
+) error {
+	nextRules, err := loadRouting(next)
+	if err != nil {
+		return fmt.Errorf("routing rules: %w", err)
+	}
+
+	nextChannels, err := channels.Load(next)
+	if err != nil {
+		return err
+	}
+
+	rules.Replace(nextRules)
+	channelRegistry.Replace(nextChannels)
+	clickUp.Reconfigure(next)
+
+	for _, field := range config.Changed(current, next) {
+		if !reloadable[field] {
+			slog.Warn("Setting changed, restart to apply it", "setting", field)
+		}
+	}
+
+	slog.Info("Applied routing rules, channels and ClickUp settings", "rules", len(nextRules.Rules()), "channels", len(nextChannels.All()))
+	return nil
+}
+
+// fatal logs a startup failure and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }
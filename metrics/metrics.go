@@ -0,0 +1,158 @@
+package metrics
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "prisma_webhook"
+
+// Webhook intake
+var (
+	WebhooksReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhooks_received_total",
+		Help:      "Webhook deliveries accepted for processing, by channel and API key ID.",
+	}, []string{"x_type", "key_id"})
+
+	AlertsReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "alerts_received_total",
+		Help:      "Alerts received in webhook deliveries, by severity and cloud type.",
+	}, []string{"severity", "cloud_type"})
+
+	TestMessages = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "test_messages_total",
+		Help:      "Prisma Cloud test messages received.",
+	})
+
+	InvalidPayloads = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "invalid_payloads_total",
+		Help:      "Webhook deliveries rejected because the payload could not be parsed or held no alerts.",
+	})
+
+	Rejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_rejected_total",
+		Help:      "Requests rejected by the API key, signature, IP allowlist or rate limit checks, by reason.",
+	}, []string{"reason"})
+)
+
+// Processing pipeline
+var (
+	JobsProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jobs_processed_total",
+		Help:      "Queued webhook deliveries processed, by final job status.",
+	}, []string{"status"})
+
+	JobDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "job_duration_seconds",
+		Help:      "Time spent processing one queued webhook delivery.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 10),
+	})
+
+	AlertsDeduplicated = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "alerts_deduplicated_total",
+		Help:      "Re-sent alerts skipped because their tickets already exist.",
+	})
+
+	AlertsFiltered = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "alerts_filtered_total",
+		Help:      "Alerts skipped because they do not match their channel's filter, by channel.",
+	}, []string{"x_type"})
+
+	TicketsCreated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tickets_created_total",
+		Help:      "Tickets created, by sink.",
+	}, []string{"sink"})
+
+	TicketsUpdated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tickets_updated_total",
+		Help:      "Tickets updated after an alert status change, by sink.",
+	}, []string{"sink"})
+
+	Notifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "notifications_total",
+		Help:      "Notifications attempted after retries, by notifier and result (sent or failed).",
+	}, []string{"notifier", "result"})
+
+	DeadLetters = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dead_letters_total",
+		Help:      "Alerts moved to the dead-letter queue, by sink and stage.",
+	}, []string{"sink", "stage"})
+)
+
+// Outbound API calls
+var (
+	UpstreamDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upstream_request_duration_seconds",
+		Help:      "Latency of outbound API calls, by service (clickup, teams, slack, sharepoint) and operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"service", "operation"})
+
+	UpstreamResponses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_responses_total",
+		Help:      "Outbound API calls by service, operation and HTTP status code (\"error\" when no response was received).",
+	}, []string{"service", "operation", "status_code"})
+)
+
+// ObserveUpstream records the latency and status code of one outbound API call.
+// statusCode is 0 when the request failed before a response arrived.
+func ObserveUpstream(service string, operation string, start time.Time, statusCode int) {
+	UpstreamDuration.WithLabelValues(service, operation).Observe(time.Since(start).Seconds())
+
+	code := "error"
+	if statusCode > 0 {
+		code = strconv.Itoa(statusCode)
+	}
+	UpstreamResponses.WithLabelValues(service, operation, code).Inc()
+}
+
+// Known label values of alerts_received_total. The payload's values are mapped onto
+// them, anything else counting as "other", so senders cannot add unbounded series.
+var (
+	knownSeverities = []string{"critical", "high", "medium", "low", "informational"}
+	knownCloudTypes = []string{"aws", "azure", "gcp", "alibaba_cloud", "oci", "ibm"}
+)
+
+// ObserveAlert counts one received alert by its severity and cloud type
+func ObserveAlert(severity string, cloudType string) {
+	AlertsReceived.WithLabelValues(knownLabel(knownSeverities, severity), knownLabel(knownCloudTypes, cloudType)).Inc()
+}
+
+// knownLabel returns value in lowercase if it is one of known, otherwise "other"
+func knownLabel(known []string, value string) string {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if slices.Contains(known, value) {
+		return value
+	}
+	return "other"
+}
+
+// RegisterQueueDepth exposes the number of jobs waiting for a worker
+func RegisterQueueDepth(depth func() int) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queue_depth",
+		Help:      "Webhook deliveries waiting for a worker.",
+	}, func() float64 {
+		return float64(depth())
+	})
+}
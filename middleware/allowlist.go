@@ -0,0 +1,317 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Allowlist is the set of IPs and CIDR ranges allowed to call the service: the
+// static ALLOWED_IPS merged with ranges loaded from a URL or file. The loaded
+// ranges are refreshed periodically; a failed refresh keeps the last good set.
+type Allowlist struct {
+	static []netip.Prefix
+	source string
+
+	mu          sync.RWMutex
+	loaded      []netip.Prefix
+	effective   []netip.Prefix
+	lastRefresh time.Time
+	lastError   string
+
+	done chan struct{}
+	once sync.Once
+}
+
+// AllowlistStatus describes the current allowlist, for the admin endpoint
+type AllowlistStatus struct {
+	Enabled     bool      `json:"enabled"`
+	Source      string    `json:"source,omitempty"`
+	Static      []string  `json:"static"`
+	Loaded      []string  `json:"loaded"`
+	Effective   []string  `json:"effective"`
+	LastRefresh time.Time `json:"last_refresh,omitempty"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// NewAllowlist creates an allowlist from static entries and an optional source
+// (http(s) URL or file path) holding more ranges as JSON or plain text
+func NewAllowlist(static []string, source string) *Allowlist {
+	a := &Allowlist{
+		static: ParsePrefixes(static),
+		source: source,
+		done:   make(chan struct{}),
+	}
+	a.effective = mergePrefixes(a.static, nil)
+	return a
+}
+
+// Enabled reports whether requests are checked at all. With neither static
+// entries nor a source every IP is allowed.
+func (a *Allowlist) Enabled() bool {
+	return len(a.static) > 0 || a.source != ""
+}
+
+// Allows reports whether ip is in the effective set
+func (a *Allowlist) Allows(ip netip.Addr) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return containsIP(a.effective, ip)
+}
+
+// Refresh reloads the ranges from the source. On failure the previous ranges stay in effect.
+func (a *Allowlist) Refresh() error {
+	if a.source == "" {
+		return nil
+	}
+
+	loaded, err := a.load()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err != nil {
+		a.lastError = err.Error()
+		return err
+	}
+
+	a.loaded = mergePrefixes(loaded, nil)
+	a.effective = mergePrefixes(a.static, loaded)
+	a.lastRefresh = time.Now().UTC()
+	a.lastError = ""
+	return nil
+}
+
+// Start refreshes the ranges every interval until Stop is called
+func (a *Allowlist) Start(interval time.Duration) {
+	if a.source == "" || interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-a.done:
+				return
+			case <-ticker.C:
+				if err := a.Refresh(); err != nil {
+					slog.Warn("Failed to refresh IP allowlist, keeping last known ranges", "source", a.source, "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop ends the periodic refresh
+func (a *Allowlist) Stop() {
+	a.once.Do(func() { close(a.done) })
+}
+
+// Status returns the static, loaded and effective ranges
+func (a *Allowlist) Status() AllowlistStatus {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return AllowlistStatus{
+		Enabled:     a.Enabled(),
+		Source:      a.source,
+		Static:      prefixStrings(a.static),
+		Loaded:      prefixStrings(a.loaded),
+		Effective:   prefixStrings(a.effective),
+		LastRefresh: a.lastRefresh,
+		LastError:   a.lastError,
+	}
+}
+
+// load reads the source and parses the ranges it lists
+func (a *Allowlist) load() ([]netip.Prefix, error) {
+	var data []byte
+	if strings.HasPrefix(a.source, "http://") || strings.HasPrefix(a.source, "https://") {
+		client := &http.Client{Timeout: 30 * time.Second}
+		resp, err := client.Get(a.source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch %s: %w", a.source, err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed to fetch %s: status %d", a.source, resp.StatusCode)
+		}
+
+		data, err = io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", a.source, err)
+		}
+	} else {
+		var err error
+		data, err = os.ReadFile(a.source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", a.source, err)
+		}
+	}
+
+	prefixes, err := parseRanges(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", a.source, err)
+	}
+	if len(prefixes) == 0 {
+		// most likely a broken document; an empty set would lock everyone out
+		return nil, fmt.Errorf("%s lists no IP ranges", a.source)
+	}
+	return prefixes, nil
+}
+
+// Keys under which a JSON object lists ranges, as in the published ranges of AWS
+// ({"prefixes": [{"ip_prefix": ...}], "ipv6_prefixes": [{"ipv6_prefix": ...}]})
+// and Google Cloud ({"prefixes": [{"ipv4Prefix": ...}, {"ipv6Prefix": ...}]})
+var (
+	rangeListKeys = []string{"prefixes", "ipv6_prefixes"}
+	rangeKeys     = []string{"ip_prefix", "ipv6_prefix", "ipv4Prefix", "ipv6Prefix"}
+)
+
+// parseRanges extracts IP addresses and CIDR ranges from a JSON document or plain text.
+// JSON is either a list of ranges, e.g. ["192.0.2.0/24"], or an object listing them
+// under rangeListKeys, each entry a range or an object holding one under rangeKeys,
+// e.g. {"prefixes": [{"ip_prefix": "192.0.2.0/24"}]}. Other fields are ignored.
+// Plain text lists one entry per line or comma-separated, with # comments.
+func parseRanges(data []byte) ([]netip.Prefix, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && (trimmed[0] == '[' || trimmed[0] == '{') {
+		var doc interface{}
+		if err := json.Unmarshal(trimmed, &doc); err != nil {
+			return nil, err
+		}
+		return jsonRanges(doc)
+	}
+
+	var prefixes []netip.Prefix
+	for _, line := range strings.Split(string(data), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		for _, entry := range strings.Split(line, ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			p, err := ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid entry %q", entry)
+			}
+			prefixes = append(prefixes, p)
+		}
+	}
+	return prefixes, nil
+}
+
+// jsonRanges returns the ranges of a decoded JSON document, rejecting any other
+// structure than the ones parseRanges accepts
+func jsonRanges(doc interface{}) ([]netip.Prefix, error) {
+	if list, ok := doc.([]interface{}); ok {
+		prefixes := make([]netip.Prefix, 0, len(list))
+		for _, entry := range list {
+			p, err := jsonRange(entry)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, p)
+		}
+		return prefixes, nil
+	}
+
+	obj := doc.(map[string]interface{})
+	var prefixes []netip.Prefix
+	found := false
+	for _, key := range rangeListKeys {
+		value, ok := obj[key]
+		if !ok {
+			continue
+		}
+		found = true
+
+		list, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%q is not a list", key)
+		}
+		for _, entry := range list {
+			if entryObj, ok := entry.(map[string]interface{}); ok {
+				entry = rangeOf(entryObj)
+				if entry == nil {
+					return nil, fmt.Errorf("entry of %q has none of %s", key, strings.Join(rangeKeys, ", "))
+				}
+			}
+			p, err := jsonRange(entry)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, p)
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("JSON object has none of %s", strings.Join(rangeListKeys, ", "))
+	}
+	return prefixes, nil
+}
+
+// rangeOf returns the value of the first of rangeKeys set in obj, or nil
+func rangeOf(obj map[string]interface{}) interface{} {
+	for _, key := range rangeKeys {
+		if value, ok := obj[key]; ok {
+			return value
+		}
+	}
+	return nil
+}
+
+// jsonRange parses a JSON value that must be an address or range
+func jsonRange(value interface{}) (netip.Prefix, error) {
+	s, ok := value.(string)
+	if !ok {
+		return netip.Prefix{}, fmt.Errorf("invalid entry %v, want an IP range", value)
+	}
+	p, err := ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid entry %q", s)
+	}
+	return p, nil
+}
+
+// mergePrefixes returns the sorted union of both lists without duplicates
+func mergePrefixes(a []netip.Prefix, b []netip.Prefix) []netip.Prefix {
+	seen := make(map[netip.Prefix]bool, len(a)+len(b))
+	var merged []netip.Prefix
+	for _, list := range [][]netip.Prefix{a, b} {
+		for _, p := range list {
+			if !seen[p] {
+				seen[p] = true
+				merged = append(merged, p)
+			}
+		}
+	}
+
+	sort.Slice(merged, func(i, j int) bool {
+		if c := merged[i].Addr().Compare(merged[j].Addr()); c != 0 {
+			return c < 0
+		}
+		return merged[i].Bits() < merged[j].Bits()
+	})
+	return merged
+}
+
+func prefixStrings(prefixes []netip.Prefix) []string {
+	list := make([]string, 0, len(prefixes))
+	for _, p := range prefixes {
+		list = append(list, p.String())
+	}
+	return list
+}
@@ -1,22 +1,144 @@
 package middleware
 
 import (
+	"crypto/subtle"
+	"encoding/base64"
+	"log/slog"
+	"prisma-webhook/config"
+	"prisma-webhook/metrics"
+	"strings"
+	"time"
+
 	"github.com/gofiber/fiber/v2"
 )
 
-// APIKeyAuth creates a middleware that validates API key from X-API-Key header
-func APIKeyAuth(apiKey string) fiber.Handler {
+// Webhook authentication modes
+const (
+	AuthModeAPIKey = "api_key" // static X-API-Key header
+	AuthModeHMAC   = "hmac"    // HMAC-SHA256 request signature
+	AuthModeAny    = "any"     // signature when X-Signature is sent, API key otherwise
+)
+
+// ScopeAdmin is the key scope required by the admin endpoints
+const ScopeAdmin = "admin"
+
+// apiKeyIDLocal is the fiber.Ctx local holding the ID of the key that authenticated the request
+const apiKeyIDLocal = "apiKeyID"
+
+// Scope returns the scope a request needs from its API key, or "" for none
+type Scope func(c *fiber.Ctx) string
+
+// ChannelScope requires the key to allow the webhook's channel
+func ChannelScope(c *fiber.Ctx) string {
+	return ChannelName(c)
+}
+
+// StaticScope requires the key to allow scope
+func StaticScope(scope string) Scope {
+	return func(c *fiber.Ctx) string {
+		return scope
+	}
+}
+
+// APIKeyAuth creates a middleware that validates the X-API-Key header against the
+// configured keys. Expired keys are rejected, and when scope is not nil the key must
+// allow the scope it returns.
+func APIKeyAuth(keys []config.APIKey, scope Scope) fiber.Handler {
+	return apiKeyAuth(keys, scope, false)
+}
+
+// BrowserAPIKeyAuth is APIKeyAuth for pages opened in a browser: the key can also be
+// sent as the HTTP Basic password, and a missing or wrong key asks the browser for it.
+// Only the dashboard uses it, so that API clients are never offered Basic auth.
+func BrowserAPIKeyAuth(keys []config.APIKey, scope Scope) fiber.Handler {
+	return apiKeyAuth(keys, scope, true)
+}
+
+func apiKeyAuth(keys []config.APIKey, scope Scope, basic bool) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Get API key from header
-		key := c.Get("X-API-Key")
+		given := []byte(c.Get("X-API-Key"))
+		if len(given) == 0 && basic {
+			given = []byte(basicAuthPassword(c))
+		}
+
+		// Compare against every key so the match position does not leak through timing
+		var matched *config.APIKey
+		for i := range keys {
+			if subtle.ConstantTimeCompare(given, []byte(keys[i].Key)) == 1 {
+				matched = &keys[i]
+			}
+		}
 
-		// Validate API key
-		if key == "" || key != apiKey {
+		if len(given) == 0 || matched == nil {
+			metrics.Rejections.WithLabelValues("api_key").Inc()
+			if basic {
+				c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="prisma-webhook"`)
+			}
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"error": "Unauthorized: Invalid or missing API key",
 			})
 		}
 
+		if matched.Expired(time.Now()) {
+			slog.InfoContext(c.UserContext(), "Rejected expired API key", "keyId", matched.ID, "clientIp", ClientIP(c))
+			metrics.Rejections.WithLabelValues("expired_key").Inc()
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Unauthorized: API key expired",
+			})
+		}
+
+		if scope != nil {
+			if s := scope(c); s != "" && !matched.Allows(s) {
+				slog.InfoContext(c.UserContext(), "Rejected API key: scope not allowed", "keyId", matched.ID, "clientIp", ClientIP(c), "scope", s)
+				metrics.Rejections.WithLabelValues("scope").Inc()
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+					"error": "Forbidden: API key not allowed for " + s,
+				})
+			}
+		}
+
+		c.Locals(apiKeyIDLocal, matched.ID)
 		return c.Next()
 	}
 }
+
+// basicAuthPassword returns the password of an HTTP Basic Authorization header, or ""
+func basicAuthPassword(c *fiber.Ctx) string {
+	scheme, encoded, ok := strings.Cut(c.Get(fiber.HeaderAuthorization), " ")
+	if !ok || !strings.EqualFold(scheme, "Basic") {
+		return ""
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return ""
+	}
+	_, password, _ := strings.Cut(string(decoded), ":")
+	return password
+}
+
+// APIKeyID returns the ID of the API key that authenticated the request, or "" if none did
+func APIKeyID(c *fiber.Ctx) string {
+	id, _ := c.Locals(apiKeyIDLocal).(string)
+	return id
+}
+
+// WebhookAuth creates the webhook authentication middleware for the configured mode
+func WebhookAuth(mode string, keys []config.APIKey, signingSecret string, tolerance time.Duration) fiber.Handler {
+	switch mode {
+	case AuthModeHMAC:
+		return SignatureAuth(signingSecret, tolerance)
+	case AuthModeAny:
+		signed := SignatureAuth(signingSecret, tolerance)
+		keyed := APIKeyAuth(keys, ChannelScope)
+		return func(c *fiber.Ctx) error {
+			if c.Get(SignatureHeader) != "" {
+				return signed(c)
+			}
+			return keyed(c)
+		}
+	default:
+		return APIKeyAuth(keys, ChannelScope)
+	}
+}
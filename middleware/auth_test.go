@@ -0,0 +1,177 @@
+package middleware
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"prisma-webhook/config"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var testKeys = []config.APIKey{
+	{ID: "current", Key: "key-current"},
+	{ID: "old", Key: "key-old", ExpiresAt: time.Now().Add(-time.Hour)},
+	{ID: "rotating", Key: "key-rotating", ExpiresAt: time.Now().Add(time.Hour)},
+	{ID: "alerta-only", Key: "key-alerta", Scopes: []string{"alerta"}},
+	{ID: "admin", Key: "key-admin", Scopes: []string{"ADMIN", "mandatory"}},
+}
+
+// authApp serves the webhook routes behind APIKeyAuth with the channel scope, /admin
+// behind the admin scope and /dashboard behind BrowserAPIKeyAuth. Each answers with
+// the ID of the key that authenticated the request.
+func authApp() *fiber.App {
+	keyID := func(c *fiber.Ctx) error {
+		return c.SendString(APIKeyID(c))
+	}
+	admin := StaticScope(ScopeAdmin)
+
+	app := fiber.New()
+	app.Post("/webhook", APIKeyAuth(testKeys, ChannelScope), keyID)
+	app.Post("/webhook/:channel", APIKeyAuth(testKeys, ChannelScope), keyID)
+	app.Get("/jobs", APIKeyAuth(testKeys, nil), keyID)
+	app.Get("/admin", APIKeyAuth(testKeys, admin), keyID)
+	app.Get("/dashboard", BrowserAPIKeyAuth(testKeys, admin), keyID)
+	return app
+}
+
+func basic(password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte("anyone:"+password))
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		headers map[string]string
+		want    int
+		wantID  string // key ID on success, error message otherwise
+	}{
+		{"no key", "POST", "/webhook/alerta", nil, 401, "Unauthorized: Invalid or missing API key"},
+		{"unknown key", "POST", "/webhook/alerta", map[string]string{"X-API-Key": "nope"}, 401, "Unauthorized: Invalid or missing API key"},
+		{"key prefix", "POST", "/webhook/alerta", map[string]string{"X-API-Key": "key-curren"}, 401, "Unauthorized: Invalid or missing API key"},
+		{"unscoped key", "POST", "/webhook/alerta", map[string]string{"X-API-Key": "key-current"}, 200, "current"},
+		{"expired key", "POST", "/webhook/alerta", map[string]string{"X-API-Key": "key-old"}, 401, "Unauthorized: API key expired"},
+		{"key not expired yet", "POST", "/webhook/alerta", map[string]string{"X-API-Key": "key-rotating"}, 200, "rotating"},
+
+		{"scoped key on its channel", "POST", "/webhook/alerta", map[string]string{"X-API-Key": "key-alerta"}, 200, "alerta-only"},
+		{"scoped key on its channel by header", "POST", "/webhook", map[string]string{"X-API-Key": "key-alerta", "X-Type": "alerta"}, 200, "alerta-only"},
+		{"scoped key on another channel", "POST", "/webhook/mandatory", map[string]string{"X-API-Key": "key-alerta"}, 403, "Forbidden: API key not allowed for mandatory"},
+		{"scoped key on another channel by header", "POST", "/webhook", map[string]string{"X-API-Key": "key-alerta", "X-Type": "mandatory"}, 403, "Forbidden: API key not allowed for mandatory"},
+		{"scopes ignore case", "POST", "/webhook/MANDATORY", map[string]string{"X-API-Key": "key-admin"}, 200, "admin"},
+
+		{"no scope required", "GET", "/jobs", map[string]string{"X-API-Key": "key-alerta"}, 200, "alerta-only"},
+		{"admin scope", "GET", "/admin", map[string]string{"X-API-Key": "key-admin"}, 200, "admin"},
+		{"unscoped key is admin", "GET", "/admin", map[string]string{"X-API-Key": "key-current"}, 200, "current"},
+		{"channel key is not admin", "GET", "/admin", map[string]string{"X-API-Key": "key-alerta"}, 403, "Forbidden: API key not allowed for admin"},
+		{"expired admin key", "GET", "/admin", map[string]string{"X-API-Key": "key-old"}, 401, "Unauthorized: API key expired"},
+
+		{"basic password on API", "GET", "/admin", map[string]string{"Authorization": basic("key-admin")}, 401, "Unauthorized: Invalid or missing API key"},
+		{"basic password on webhook", "POST", "/webhook/alerta", map[string]string{"Authorization": basic("key-current")}, 401, "Unauthorized: Invalid or missing API key"},
+		{"basic password on dashboard", "GET", "/dashboard", map[string]string{"Authorization": basic("key-admin")}, 200, "admin"},
+		{"header on dashboard", "GET", "/dashboard", map[string]string{"X-API-Key": "key-admin"}, 200, "admin"},
+		{"wrong basic password on dashboard", "GET", "/dashboard", map[string]string{"Authorization": basic("nope")}, 401, "Unauthorized: Invalid or missing API key"},
+		{"malformed basic on dashboard", "GET", "/dashboard", map[string]string{"Authorization": "Basic !!"}, 401, "Unauthorized: Invalid or missing API key"},
+		{"channel key on dashboard", "GET", "/dashboard", map[string]string{"Authorization": basic("key-alerta")}, 403, "Forbidden: API key not allowed for admin"},
+	}
+
+	app := authApp()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := string(body)
+			if resp.StatusCode != http.StatusOK {
+				var res struct {
+					Error string `json:"error"`
+				}
+				if err := json.Unmarshal(body, &res); err != nil {
+					t.Fatalf("decode error response %q: %v", body, err)
+				}
+				got = res.Error
+			}
+
+			if resp.StatusCode != tt.want || got != tt.wantID {
+				t.Fatalf("got %d %q, want %d %q", resp.StatusCode, got, tt.want, tt.wantID)
+			}
+
+			// only the dashboard asks browsers for credentials
+			challenge := resp.Header.Get(fiber.HeaderWWWAuthenticate)
+			wantChallenge := tt.path == "/dashboard" && tt.want == http.StatusUnauthorized
+			if (challenge != "") != wantChallenge {
+				t.Fatalf("WWW-Authenticate = %q, want challenge %t", challenge, wantChallenge)
+			}
+		})
+	}
+}
+
+func TestWebhookAuthModes(t *testing.T) {
+	const body = `{"alerts":[]}`
+	now := time.Now().Unix()
+	ts := strconv.FormatInt(now, 10)
+
+	tests := []struct {
+		name      string
+		mode      string
+		apiKey    string
+		signature string
+		want      int
+	}{
+		{"api_key mode with key", AuthModeAPIKey, "key-current", "", 200},
+		{"api_key mode with signature", AuthModeAPIKey, "", sign(testSecret, now, body), 401},
+		{"hmac mode with signature", AuthModeHMAC, "", sign(testSecret, now, body), 200},
+		{"hmac mode with key", AuthModeHMAC, "key-current", "", 401},
+		{"any mode with key", AuthModeAny, "key-current", "", 200},
+		{"any mode with signature", AuthModeAny, "", sign(testSecret, now, body), 200},
+		{"any mode with bad signature and good key", AuthModeAny, "key-current", sign("other", now, body), 401},
+		{"any mode with nothing", AuthModeAny, "", "", 401},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Post("/webhook/:channel", WebhookAuth(tt.mode, testKeys, testSecret, time.Minute), func(c *fiber.Ctx) error {
+				return c.SendStatus(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/webhook/alerta", strings.NewReader(body))
+			if tt.apiKey != "" {
+				req.Header.Set("X-API-Key", tt.apiKey)
+			}
+			if tt.signature != "" {
+				req.Header.Set(SignatureTimestampHeader, ts)
+				req.Header.Set(SignatureHeader, tt.signature)
+			}
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
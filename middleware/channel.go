@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"log/slog"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ChannelName returns the channel a webhook is delivered to: the {channel} of
+// /webhook/{channel}, or the X-Type header on /webhook
+func ChannelName(c *fiber.Ctx) string {
+	if name := c.Params("channel"); name != "" {
+		return name
+	}
+	return c.Get("X-Type")
+}
+
+// Channel creates a middleware that rejects webhooks for channels that known does
+// not report as configured: 404 for an unknown path, 400 for a bad X-Type header
+func Channel(known func(name string) bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		name := ChannelName(c)
+		if known(name) {
+			return c.Next()
+		}
+
+		slog.DebugContext(c.UserContext(), "Unknown channel", "xType", name)
+		if c.Params("channel") != "" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Unknown channel",
+			})
+		}
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid or missing type header",
+		})
+	}
+}
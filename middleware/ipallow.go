@@ -1,31 +1,30 @@
 package middleware
 
 import (
-	"strings"
+	"log/slog"
+	"prisma-webhook/metrics"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-// IPAllowlist creates a middleware that restricts access to allowed IPs
-func IPAllowlist(allowedIPs []string) fiber.Handler {
+// IPAllowlist creates a middleware that restricts access to the allowlist's IPs and
+// CIDR ranges (IPv4 or IPv6). The client IP is the one resolved by RealIP.
+func IPAllowlist(allowlist *Allowlist) fiber.Handler {
 	// If no IPs configured, skip IP check
-	if len(allowedIPs) == 0 {
+	if !allowlist.Enabled() {
 		return func(c *fiber.Ctx) error {
 			return c.Next()
 		}
 	}
 
-	// Create a map for faster lookup
-	ipMap := make(map[string]bool)
-	for _, ip := range allowedIPs {
-		ipMap[strings.TrimSpace(ip)] = true
-	}
-
 	return func(c *fiber.Ctx) error {
-		clientIP := c.IP()
+		clientIP := ClientIP(c)
 
 		// Check if IP is in allowlist
-		if !ipMap[clientIP] {
+		ip, ok := parseIP(clientIP)
+		if !ok || !allowlist.Allows(ip) {
+			slog.InfoContext(c.UserContext(), "Access denied: IP not allowed", "method", c.Method(), "path", c.Path(), "clientIp", clientIP, "peerIp", c.IP())
+			metrics.Rejections.WithLabelValues("ip").Inc()
 			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
 				"error": "Access denied: IP not allowed",
 			})
@@ -0,0 +1,159 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestIPAllowlist(t *testing.T) {
+	// requests sent through fiber's App.Test arrive from 0.0.0.0, which is trusted
+	// so that X-Forwarded-For names the client
+	trusted := []string{"0.0.0.0"}
+
+	tests := []struct {
+		name    string
+		allowed []string
+		client  string
+		want    int
+	}{
+		{"no allowlist allows everyone", nil, "203.0.113.7", 200},
+		{"exact IPv4", []string{"203.0.113.7"}, "203.0.113.7", 200},
+		{"other IPv4", []string{"203.0.113.7"}, "203.0.113.8", 403},
+		{"IPv4 range", []string{"203.0.113.0/24"}, "203.0.113.200", 200},
+		{"outside IPv4 range", []string{"203.0.113.0/24"}, "203.0.114.1", 403},
+		{"IPv6 range", []string{"2001:db8::/32"}, "2001:db8:1::5", 200},
+		{"outside IPv6 range", []string{"2001:db8::/32"}, "2001:db9::5", 403},
+		{"IPv4-mapped client in IPv4 range", []string{"203.0.113.0/24"}, "::ffff:203.0.113.9", 200},
+		{"IPv4 client against IPv6 range", []string{"::/0"}, "203.0.113.9", 403},
+		{"several entries", []string{"198.51.100.1", "2001:db8::/32", "203.0.113.0/24"}, "203.0.113.9", 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Use(RealIP(trusted), IPAllowlist(NewAllowlist(tt.allowed, "")))
+			app.Get("/", func(c *fiber.Ctx) error {
+				return c.SendStatus(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(fiber.HeaderXForwardedFor, tt.client)
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+func TestIPAllowlistUsesPeerOfUntrustedProxy(t *testing.T) {
+	app := fiber.New()
+	app.Use(RealIP(nil), IPAllowlist(NewAllowlist([]string{"203.0.113.7"}, "")))
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendStatus(http.StatusOK)
+	})
+
+	// the header cannot talk the client into the allowlist
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(fiber.HeaderXForwardedFor, "203.0.113.7")
+	req.Header.Set("X-Real-IP", "203.0.113.7")
+
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("status = %d, want 403", resp.StatusCode)
+	}
+}
+
+func TestParseRanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []string
+		wantErr bool
+	}{
+		{"plain text", "192.0.2.0/24\n# office\n2001:db8::1 # vpn\n", []string{"192.0.2.0/24", "2001:db8::1/128"}, false},
+		{"comma-separated", "192.0.2.1, 192.0.2.2", []string{"192.0.2.1/32", "192.0.2.2/32"}, false},
+		{"invalid plain entry", "192.0.2.0/24\nnot-an-ip\n", nil, true},
+		{"JSON list", `["192.0.2.0/24", "2001:db8::/32"]`, []string{"192.0.2.0/24", "2001:db8::/32"}, false},
+		{"nested JSON skips other strings", `{"syncToken": "1", "prefixes": [{"ip_prefix": "192.0.2.0/24", "region": "eu"}]}`, []string{"192.0.2.0/24"}, false},
+		{"AWS ranges", `{"prefixes": [{"ip_prefix": "192.0.2.0/24"}], "ipv6_prefixes": [{"ipv6_prefix": "2001:db8::/32"}]}`, []string{"192.0.2.0/24", "2001:db8::/32"}, false},
+		{"Google Cloud ranges", `{"prefixes": [{"ipv4Prefix": "192.0.2.0/24"}, {"ipv6Prefix": "2001:db8::/32"}]}`, []string{"192.0.2.0/24", "2001:db8::/32"}, false},
+		{"JSON object listing strings", `{"prefixes": ["192.0.2.1"]}`, []string{"192.0.2.1/32"}, false},
+		{"JSON list with other strings", `["192.0.2.0/24", "office"]`, nil, true},
+		{"JSON list of objects", `[{"ip_prefix": "192.0.2.0/24"}]`, nil, true},
+		{"ranges under other keys", `{"meta": {"ranges": ["192.0.2.0/24"]}}`, nil, true},
+		{"ranges beside the known keys", `{"prefixes": [], "extra": ["198.51.100.0/24"]}`, nil, false},
+		{"prefixes not a list", `{"prefixes": "192.0.2.0/24"}`, nil, true},
+		{"entry without a range", `{"prefixes": [{"region": "eu"}]}`, nil, true},
+		{"invalid range in entry", `{"prefixes": [{"ip_prefix": "eu-west-1"}]}`, nil, true},
+		{"broken JSON", `{"prefixes": [`, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRanges([]byte(tt.data))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseRanges = %v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(prefixStrings(got), ",") != strings.Join(tt.want, ",") {
+				t.Fatalf("parseRanges = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllowlistRefresh(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "ranges.txt")
+	a := NewAllowlist([]string{"198.51.100.1"}, source)
+
+	steps := []struct {
+		name    string
+		content string // written to the source before the refresh, "" removes it
+		wantErr bool
+		allowed map[string]bool
+	}{
+		{"loads ranges", "203.0.113.0/24", false, map[string]bool{"203.0.113.9": true, "198.51.100.1": true, "192.0.2.1": false}},
+		{"replaces ranges", "192.0.2.0/24", false, map[string]bool{"203.0.113.9": false, "192.0.2.1": true, "198.51.100.1": true}},
+		{"empty document keeps the last ranges", "# nothing\n", true, map[string]bool{"192.0.2.1": true}},
+		{"missing source keeps the last ranges", "", true, map[string]bool{"192.0.2.1": true, "198.51.100.1": true}},
+	}
+
+	for _, s := range steps {
+		if s.content == "" {
+			os.Remove(source)
+		} else if err := os.WriteFile(source, []byte(s.content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := a.Refresh(); (err != nil) != s.wantErr {
+			t.Fatalf("%s: Refresh error = %v, want error %t", s.name, err, s.wantErr)
+		}
+		for ip, want := range s.allowed {
+			if got := a.Allows(netip.MustParseAddr(ip)); got != want {
+				t.Errorf("%s: Allows(%s) = %t, want %t", s.name, ip, got, want)
+			}
+		}
+	}
+}
@@ -1,40 +1,182 @@
 package middleware
 
 import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"prisma-webhook/config"
+	"prisma-webhook/metrics"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/limiter"
+	"github.com/redis/go-redis/v9"
 )
 
-// GeneralRateLimit returns a rate limiter for general endpoints
-func GeneralRateLimit() fiber.Handler {
-	return limiter.New(limiter.Config{
-		Max:        60,
-		Expiration: 1 * time.Minute,
-		KeyGenerator: func(c *fiber.Ctx) string {
-			return c.IP()
-		},
-		LimitReached: func(c *fiber.Ctx) error {
-			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
-				"error": "Rate limit exceeded. Please try again later.",
-			})
-		},
-	})
-}
-
-// WebhookRateLimit returns a rate limiter for webhook endpoint
-func WebhookRateLimit() fiber.Handler {
-	return limiter.New(limiter.Config{
-		Max:        100,
-		Expiration: 1 * time.Minute,
-		KeyGenerator: func(c *fiber.Ctx) string {
-			return c.IP()
-		},
-		LimitReached: func(c *fiber.Ctx) error {
+// RateLimitStore counts requests per key in fixed windows
+type RateLimitStore interface {
+	// Incr adds a hit to key's current window and returns the hit count and the
+	// time left until the window resets
+	Incr(ctx context.Context, key string, window time.Duration) (int, time.Duration, error)
+}
+
+// RateLimiter applies per-route limits, counted per API key when the request was
+// authenticated and per client IP otherwise
+type RateLimiter struct {
+	store  RateLimitStore
+	keys   map[string]config.RateLimit // per API key ID overrides
+	prefix string
+}
+
+// NewRateLimiter creates a limiter counting in store. keyLimits override the
+// route limit for requests authenticated with those API keys.
+func NewRateLimiter(store RateLimitStore, keys []config.APIKey) *RateLimiter {
+	keyLimits := make(map[string]config.RateLimit)
+	for _, k := range keys {
+		if k.RateLimit != nil {
+			keyLimits[k.ID] = *k.RateLimit
+		}
+	}
+
+	return &RateLimiter{
+		store:  store,
+		keys:   keyLimits,
+		prefix: "ratelimit:",
+	}
+}
+
+// Limit returns a middleware enforcing limit on the named route. It sets the
+// RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers, and
+// Retry-After when the limit is exceeded.
+func (r *RateLimiter) Limit(route string, limit config.RateLimit) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		lim := limit
+		subject := "ip:" + ClientIP(c)
+		if id := APIKeyID(c); id != "" {
+			subject = "key:" + id
+			if keyLimit, ok := r.keys[id]; ok {
+				lim = keyLimit
+			}
+		}
+
+		if lim.Max <= 0 {
+			return c.Next()
+		}
+
+		count, reset, err := r.store.Incr(c.UserContext(), r.prefix+route+":"+subject, lim.Window)
+		if err != nil {
+			// a limiter outage must not turn into dropped alerts
+			slog.WarnContext(c.UserContext(), "Rate limit store unavailable, allowing request", "error", err)
+			return c.Next()
+		}
+
+		resetSeconds := strconv.Itoa(int(math.Ceil(reset.Seconds())))
+		c.Set("RateLimit-Limit", strconv.Itoa(lim.Max))
+		c.Set("RateLimit-Remaining", strconv.Itoa(max(lim.Max-count, 0)))
+		c.Set("RateLimit-Reset", resetSeconds)
+
+		if count > lim.Max {
+			slog.InfoContext(c.UserContext(), "Rate limit exceeded", "route", route, "subject", subject, "limit", lim.String())
+			metrics.Rejections.WithLabelValues("rate_limit").Inc()
+			c.Set(fiber.HeaderRetryAfter, resetSeconds)
 			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
 				"error": "Rate limit exceeded. Please try again later.",
 			})
-		},
-	})
+		}
+
+		return c.Next()
+	}
+}
+
+// NewRateLimitStore returns the store selected by the configuration: in-memory,
+// or Redis so that several replicas share their counters
+func NewRateLimitStore(cfg *config.Config) (RateLimitStore, error) {
+	switch cfg.RateLimitStore {
+	case "redis":
+		opts, err := redis.ParseURL(cfg.RedisURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+		}
+		return &RedisRateLimitStore{client: redis.NewClient(opts)}, nil
+	default:
+		return NewMemoryRateLimitStore(), nil
+	}
+}
+
+// MemoryRateLimitStore keeps counters in process memory
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	windows   map[string]*rateWindow
+	lastPrune time.Time
+}
+
+type rateWindow struct {
+	count   int
+	expires time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{windows: make(map[string]*rateWindow)}
+}
+
+// Incr implements RateLimitStore
+func (s *MemoryRateLimitStore) Incr(_ context.Context, key string, window time.Duration) (int, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastPrune) > time.Minute {
+		for k, w := range s.windows {
+			if now.After(w.expires) {
+				delete(s.windows, k)
+			}
+		}
+		s.lastPrune = now
+	}
+
+	w, ok := s.windows[key]
+	if !ok || now.After(w.expires) {
+		w = &rateWindow{expires: now.Add(window)}
+		s.windows[key] = w
+	}
+	w.count++
+
+	return w.count, w.expires.Sub(now), nil
+}
+
+// RedisRateLimitStore keeps counters in Redis, shared by every replica
+type RedisRateLimitStore struct {
+	client *redis.Client
+}
+
+// incrScript increments the counter and starts its window on the first hit
+var incrScript = redis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+if count == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl < 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+	ttl = tonumber(ARGV[1])
+end
+return {count, ttl}
+`)
+
+// Incr implements RateLimitStore
+func (s *RedisRateLimitStore) Incr(ctx context.Context, key string, window time.Duration) (int, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	res, err := incrScript.Run(ctx, s.client, []string{key}, window.Milliseconds()).Int64Slice()
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(res) != 2 {
+		return 0, 0, fmt.Errorf("unexpected rate limit script result %v", res)
+	}
+
+	return int(res[0]), time.Duration(res[1]) * time.Millisecond, nil
 }
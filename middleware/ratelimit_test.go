@@ -0,0 +1,185 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"prisma-webhook/config"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// failingStore is a rate limit store that is always unavailable
+type failingStore struct{}
+
+func (failingStore) Incr(context.Context, string, time.Duration) (int, time.Duration, error) {
+	return 0, 0, errors.New("connection refused")
+}
+
+// limitedApp serves /a and /b, each with its own limit, behind APIKeyAuth for
+// requests carrying a key. Requests without a key are counted per client IP,
+// taken from X-Forwarded-For as the test peer 0.0.0.0 is a trusted proxy.
+func limitedApp(store RateLimitStore, limit config.RateLimit) *fiber.App {
+	keys := []config.APIKey{
+		{ID: "plain", Key: "key-plain"},
+		{ID: "bulk", Key: "key-bulk", RateLimit: &config.RateLimit{Max: 5, Window: time.Minute}},
+	}
+	limiter := NewRateLimiter(store, keys)
+	auth := APIKeyAuth(keys, nil)
+
+	app := fiber.New()
+	app.Use(RealIP([]string{"0.0.0.0"}), func(c *fiber.Ctx) error {
+		if c.Get("X-API-Key") != "" {
+			return auth(c)
+		}
+		return c.Next()
+	})
+	ok := func(c *fiber.Ctx) error {
+		return c.SendStatus(http.StatusOK)
+	}
+	app.Get("/a", limiter.Limit("a", limit), ok)
+	app.Get("/b", limiter.Limit("b", limit), ok)
+	return app
+}
+
+type limitedRequest struct {
+	path   string
+	ip     string
+	apiKey string
+
+	want          int
+	wantRemaining string
+}
+
+func TestRateLimiter(t *testing.T) {
+	limit := config.RateLimit{Max: 2, Window: time.Minute}
+
+	tests := []struct {
+		name     string
+		requests []limitedRequest
+	}{
+		{"counts per client IP", []limitedRequest{
+			{"/a", "203.0.113.1", "", 200, "1"},
+			{"/a", "203.0.113.1", "", 200, "0"},
+			{"/a", "203.0.113.1", "", 429, "0"},
+			{"/a", "203.0.113.2", "", 200, "1"},
+		}},
+		{"counts per route", []limitedRequest{
+			{"/a", "203.0.113.1", "", 200, "1"},
+			{"/a", "203.0.113.1", "", 200, "0"},
+			{"/b", "203.0.113.1", "", 200, "1"},
+			{"/a", "203.0.113.1", "", 429, "0"},
+		}},
+		{"counts per key across IPs", []limitedRequest{
+			{"/a", "203.0.113.1", "key-plain", 200, "1"},
+			{"/a", "203.0.113.2", "key-plain", 200, "0"},
+			{"/a", "203.0.113.3", "key-plain", 429, "0"},
+			{"/a", "203.0.113.3", "", 200, "1"},
+		}},
+		{"key limit overrides the route limit", []limitedRequest{
+			{"/a", "203.0.113.1", "key-bulk", 200, "4"},
+			{"/a", "203.0.113.1", "key-bulk", 200, "3"},
+			{"/a", "203.0.113.1", "key-bulk", 200, "2"},
+			{"/a", "203.0.113.1", "key-plain", 200, "1"},
+			{"/a", "203.0.113.1", "key-bulk", 200, "1"},
+			{"/a", "203.0.113.1", "key-bulk", 200, "0"},
+			{"/a", "203.0.113.1", "key-bulk", 429, "0"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := limitedApp(NewMemoryRateLimitStore(), limit)
+
+			for i, r := range tt.requests {
+				req := httptest.NewRequest(http.MethodGet, r.path, nil)
+				req.Header.Set(fiber.HeaderXForwardedFor, r.ip)
+				if r.apiKey != "" {
+					req.Header.Set("X-API-Key", r.apiKey)
+				}
+
+				resp, err := app.Test(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+
+				if resp.StatusCode != r.want {
+					t.Fatalf("request %d: status = %d, want %d", i+1, resp.StatusCode, r.want)
+				}
+				if got := resp.Header.Get("RateLimit-Remaining"); got != r.wantRemaining {
+					t.Fatalf("request %d: RateLimit-Remaining = %s, want %s", i+1, got, r.wantRemaining)
+				}
+				if r.want == http.StatusTooManyRequests && resp.Header.Get(fiber.HeaderRetryAfter) == "" {
+					t.Fatalf("request %d: no Retry-After on 429", i+1)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterBypass(t *testing.T) {
+	tests := []struct {
+		name  string
+		store RateLimitStore
+		limit config.RateLimit
+	}{
+		{"limit 0 turns limiting off", NewMemoryRateLimitStore(), config.RateLimit{Max: 0, Window: time.Minute}},
+		{"unavailable store allows requests", failingStore{}, config.RateLimit{Max: 1, Window: time.Minute}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := limitedApp(tt.store, tt.limit)
+			for i := 0; i < 3; i++ {
+				resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/a", nil))
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					t.Fatalf("request %d: status = %d, want 200", i+1, resp.StatusCode)
+				}
+				if got := resp.Header.Get("RateLimit-Limit"); got != "" {
+					t.Fatalf("request %d: RateLimit-Limit = %s, want none", i+1, got)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryRateLimitStoreWindow(t *testing.T) {
+	s := NewMemoryRateLimitStore()
+	ctx := context.Background()
+	window := 50 * time.Millisecond
+
+	steps := []struct {
+		key   string
+		sleep time.Duration
+		want  int
+	}{
+		{"a", 0, 1},
+		{"a", 0, 2},
+		{"b", 0, 1},
+		{"a", window + 10*time.Millisecond, 1},
+		{"a", 0, 2},
+	}
+
+	for i, step := range steps {
+		time.Sleep(step.sleep)
+		count, reset, err := s.Incr(ctx, step.key, window)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != step.want {
+			t.Fatalf("step %d: count = %d, want %d", i+1, count, step.want)
+		}
+		if reset <= 0 || reset > window {
+			t.Fatalf("step %d: reset = %s, want within (0, %s]", i+1, reset, window)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"net/netip"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// clientIPLocal is the fiber.Ctx local holding the resolved client IP
+const clientIPLocal = "clientIP"
+
+// RealIP creates a middleware that resolves the client IP of requests arriving
+// through a trusted reverse proxy. Only when the direct peer is in trustedProxies
+// are X-Forwarded-For (walked right to left, skipping trusted hops) and, without
+// it, X-Real-IP honored, so clients cannot spoof their address with these headers.
+func RealIP(trustedProxies []string) fiber.Handler {
+	trusted := ParsePrefixes(trustedProxies)
+
+	return func(c *fiber.Ctx) error {
+		c.Locals(clientIPLocal, resolveClientIP(c, trusted))
+		return c.Next()
+	}
+}
+
+// ClientIP returns the client IP resolved by RealIP, or the peer address if RealIP is not in use
+func ClientIP(c *fiber.Ctx) string {
+	if ip, ok := c.Locals(clientIPLocal).(string); ok {
+		return ip
+	}
+	return c.IP()
+}
+
+func resolveClientIP(c *fiber.Ctx, trusted []netip.Prefix) string {
+	peer, ok := parseIP(c.Context().RemoteIP().String())
+	if !ok {
+		return c.IP()
+	}
+	if !containsIP(trusted, peer) {
+		return peer.String()
+	}
+
+	if xff := c.Get(fiber.HeaderXForwardedFor); xff != "" {
+		// last is the rightmost trusted hop so far, starting with the peer
+		last := peer
+		hops := strings.Split(xff, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip, ok := parseIP(hops[i])
+			if !ok {
+				// a malformed hop cannot be trusted to lead further, and neither can
+				// X-Real-IP, which the client may have set; stop at the last good hop
+				return last.String()
+			}
+			if i == 0 || !containsIP(trusted, ip) {
+				return ip.String()
+			}
+			last = ip
+		}
+	}
+
+	if ip, ok := parseIP(c.Get("X-Real-IP")); ok {
+		return ip.String()
+	}
+
+	return peer.String()
+}
+
+// ParsePrefixes parses IP addresses and CIDR ranges (IPv4 or IPv6); a bare address
+// becomes a single-address prefix. Invalid entries are skipped.
+func ParsePrefixes(list []string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, s := range list {
+		if p, err := ParsePrefix(s); err == nil {
+			prefixes = append(prefixes, p)
+		}
+	}
+	return prefixes
+}
+
+// ParsePrefix parses an IP address or CIDR range
+func ParsePrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		if p.Addr().Is4In6() && p.Bits() >= 96 {
+			p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+		}
+		return p.Masked(), nil
+	}
+
+	ip, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	ip = ip.Unmap().WithZone("")
+	return netip.PrefixFrom(ip, ip.BitLen()), nil
+}
+
+func parseIP(s string) (netip.Addr, bool) {
+	ip, err := netip.ParseAddr(strings.TrimSpace(s))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ip.Unmap().WithZone(""), true
+}
+
+func containsIP(prefixes []netip.Prefix, ip netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// realIPApp answers with the client IP RealIP resolved. Requests sent through
+// fiber's App.Test arrive from the peer 0.0.0.0.
+func realIPApp(trustedProxies []string) *fiber.App {
+	app := fiber.New()
+	app.Use(RealIP(trustedProxies))
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString(ClientIP(c))
+	})
+	return app
+}
+
+func TestRealIP(t *testing.T) {
+	trustedPeer := []string{"0.0.0.0", "10.0.0.0/8", "fd00::/8"}
+
+	tests := []struct {
+		name    string
+		trusted []string
+		xff     string
+		realIP  string
+		want    string
+	}{
+		{"untrusted peer ignores headers", []string{"10.0.0.0/8"}, "203.0.113.7", "203.0.113.8", "0.0.0.0"},
+		{"no proxies trusted", nil, "203.0.113.7", "", "0.0.0.0"},
+		{"trusted peer without headers", trustedPeer, "", "", "0.0.0.0"},
+
+		{"single hop", trustedPeer, "203.0.113.7", "", "203.0.113.7"},
+		{"rightmost untrusted hop wins", trustedPeer, "198.51.100.1, 203.0.113.7", "", "203.0.113.7"},
+		{"trusted hops are skipped", trustedPeer, "198.51.100.1, 203.0.113.7, 10.1.2.3, 10.0.0.1", "", "203.0.113.7"},
+		{"all hops trusted", trustedPeer, "10.0.0.9, 10.0.0.1", "", "10.0.0.9"},
+		{"spoofed leftmost hop is ignored", trustedPeer, "1.1.1.1, 203.0.113.7", "", "203.0.113.7"},
+		{"IPv6 hop", trustedPeer, "2001:db8::1, fd00::5", "", "2001:db8::1"},
+		{"IPv4-mapped hop is unmapped", trustedPeer, "::ffff:203.0.113.7", "", "203.0.113.7"},
+		{"hop with zone", trustedPeer, "fe80::1%eth0", "", "fe80::1"},
+
+		{"X-Forwarded-For wins over X-Real-IP", trustedPeer, "203.0.113.7", "203.0.113.8", "203.0.113.7"},
+		{"X-Real-IP without X-Forwarded-For", trustedPeer, "", "203.0.113.8", "203.0.113.8"},
+		{"malformed X-Real-IP", trustedPeer, "", "nope", "0.0.0.0"},
+
+		{"malformed rightmost hop stops at the peer", trustedPeer, "203.0.113.7, garbage", "203.0.113.8", "0.0.0.0"},
+		{"malformed hop stops at the last trusted hop", trustedPeer, "203.0.113.7, garbage, 10.0.0.2, 10.0.0.1", "203.0.113.8", "10.0.0.2"},
+		{"malformed hop behind an untrusted one is not reached", trustedPeer, "garbage, 203.0.113.7, 10.0.0.1", "203.0.113.8", "203.0.113.7"},
+		{"empty hop is malformed", trustedPeer, "203.0.113.7, , 10.0.0.1", "203.0.113.8", "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.xff != "" {
+				req.Header.Set(fiber.HeaderXForwardedFor, tt.xff)
+			}
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+
+			resp, err := realIPApp(tt.trusted).Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			got, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Fatalf("client IP = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePrefix(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"192.0.2.1", "192.0.2.1/32", false},
+		{" 192.0.2.0/24 ", "192.0.2.0/24", false},
+		{"192.0.2.77/24", "192.0.2.0/24", false},
+		{"2001:db8::1", "2001:db8::1/128", false},
+		{"2001:db8::/32", "2001:db8::/32", false},
+		{"::ffff:192.0.2.1", "192.0.2.1/32", false},
+		{"::ffff:192.0.2.0/120", "192.0.2.0/24", false},
+		{"fe80::1%eth0", "fe80::1/128", false},
+		{"192.0.2.0/33", "", true},
+		{"example.com", "", true},
+		{"", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParsePrefix(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParsePrefix(%q) = %s, want error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got.String() != tt.want {
+			t.Errorf("ParsePrefix(%q) = %s, %v, want %s", tt.in, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"log/slog"
+	"prisma-webhook/logging"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader carries the request ID. A caller-supplied ID is kept, otherwise one
+// is generated; either way it is echoed in the response.
+const RequestIDHeader = "X-Request-ID"
+
+// RequestLog creates a middleware that assigns each request an ID and logs the
+// request once it has been handled. Health checks and metric scrapes are logged at
+// debug level. The ID is stored in the user context, so every log record written
+// with that context carries it.
+func RequestLog() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.NewString()
+		}
+		c.Set(RequestIDHeader, id)
+		c.SetUserContext(logging.WithRequestID(c.UserContext(), id))
+
+		start := time.Now()
+		err := c.Next()
+
+		status := c.Response().StatusCode()
+		if err != nil {
+			status = fiber.StatusInternalServerError
+			if e, ok := err.(*fiber.Error); ok {
+				status = e.Code
+			}
+		}
+
+		level := slog.LevelInfo
+		if path := c.Path(); path == "/health" || path == "/ready" || path == "/metrics" {
+			level = slog.LevelDebug
+		}
+
+		attrs := []any{
+			"method", c.Method(),
+			"path", c.Path(),
+			"status", status,
+			"duration", time.Since(start),
+			"clientIp", ClientIP(c),
+		}
+		if keyID := APIKeyID(c); keyID != "" {
+			attrs = append(attrs, "keyId", keyID)
+		}
+		if err != nil {
+			attrs = append(attrs, "error", err)
+		}
+		slog.Log(c.UserContext(), level, "Request handled", attrs...)
+
+		return err
+	}
+}
+
+// validRequestID accepts short IDs of printable ASCII so a caller cannot inject
+// control characters into the logs
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,195 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const testSecret = "s3cret"
+
+// sign returns the X-Signature value for body signed at ts with secret
+func sign(secret string, ts int64, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(ts, 10) + "." + body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// signedApp serves POST / behind SignatureAuth, answering with the API key ID set
+func signedApp(tolerance time.Duration) *fiber.App {
+	app := fiber.New()
+	app.Post("/", SignatureAuth(testSecret, tolerance), func(c *fiber.Ctx) error {
+		return c.SendString(APIKeyID(c))
+	})
+	return app
+}
+
+// post sends body with the signature headers, leaving out empty ones, and returns
+// the status and the error message of a rejection
+func post(t *testing.T, app *fiber.App, body, timestamp, signature string) (int, string) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	if timestamp != "" {
+		req.Header.Set(SignatureTimestampHeader, timestamp)
+	}
+	if signature != "" {
+		req.Header.Set(SignatureHeader, signature)
+	}
+
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var res struct {
+		Error string `json:"error"`
+	}
+	if resp.StatusCode != http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+			t.Fatalf("decode error response: %v", err)
+		}
+	}
+	return resp.StatusCode, res.Error
+}
+
+func TestSignatureAuth(t *testing.T) {
+	const body = `{"alerts":[]}`
+	now := time.Now().Unix()
+	ts := strconv.FormatInt(now, 10)
+
+	tests := []struct {
+		name      string
+		body      string
+		timestamp string
+		signature string
+		wantErr   string
+	}{
+		{"valid", body, ts, sign(testSecret, now, body), ""},
+		{"valid without prefix", body, ts, strings.TrimPrefix(sign(testSecret, now, body), "sha256="), ""},
+		{"valid at edge of window", body, strconv.FormatInt(now-240, 10), sign(testSecret, now-240, body), ""},
+		{"missing signature", body, ts, "", "Unauthorized: Missing request signature"},
+		{"missing timestamp", body, "", sign(testSecret, now, body), "Unauthorized: Missing request signature"},
+		{"timestamp not a number", body, "yesterday", sign(testSecret, now, body), "Unauthorized: Invalid signature timestamp"},
+		{"timestamp too old", body, strconv.FormatInt(now-600, 10), sign(testSecret, now-600, body), "Unauthorized: Signature timestamp outside the allowed window"},
+		{"timestamp in the future", body, strconv.FormatInt(now+600, 10), sign(testSecret, now+600, body), "Unauthorized: Signature timestamp outside the allowed window"},
+		{"signature not hex", body, ts, "sha256=zz", "Unauthorized: Invalid request signature"},
+		{"wrong secret", body, ts, sign("other", now, body), "Unauthorized: Invalid request signature"},
+		{"body changed", `{"alerts":[{}]}`, ts, sign(testSecret, now, body), "Unauthorized: Invalid request signature"},
+		{"timestamp changed", body, strconv.FormatInt(now-1, 10), sign(testSecret, now, body), "Unauthorized: Invalid request signature"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// a fresh app each time, so that no case is rejected as a replay of another
+			status, msg := post(t, signedApp(5*time.Minute), tt.body, tt.timestamp, tt.signature)
+
+			if tt.wantErr == "" {
+				if status != http.StatusOK {
+					t.Fatalf("status = %d (%s), want 200", status, msg)
+				}
+				return
+			}
+			if status != http.StatusUnauthorized || msg != tt.wantErr {
+				t.Fatalf("got %d %q, want 401 %q", status, msg, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSignatureAuthRejectsReplay(t *testing.T) {
+	const body = `{"alerts":[]}`
+	app := signedApp(5 * time.Minute)
+	now := time.Now().Unix()
+
+	tests := []struct {
+		name    string
+		ts      int64
+		spell   func(string) string // rewrites the signature header
+		wantErr string
+	}{
+		{"first delivery", now, nil, ""},
+		{"same signature again", now, nil, "Unauthorized: Replayed request signature"},
+		{"same signature in upper case", now, func(s string) string { return "sha256=" + strings.ToUpper(strings.TrimPrefix(s, "sha256=")) }, "Unauthorized: Replayed request signature"},
+		{"same signature without prefix", now, func(s string) string { return strings.TrimPrefix(s, "sha256=") }, "Unauthorized: Replayed request signature"},
+		{"same body signed again later", now + 1, nil, ""},
+		{"later signature again", now + 1, nil, "Unauthorized: Replayed request signature"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signature := sign(testSecret, tt.ts, body)
+			if tt.spell != nil {
+				signature = tt.spell(signature)
+			}
+			status, msg := post(t, app, body, strconv.FormatInt(tt.ts, 10), signature)
+
+			if tt.wantErr == "" {
+				if status != http.StatusOK {
+					t.Fatalf("status = %d (%s), want 200", status, msg)
+				}
+				return
+			}
+			if status != http.StatusUnauthorized || msg != tt.wantErr {
+				t.Fatalf("got %d %q, want 401 %q", status, msg, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSignatureAuthSetsKeyID(t *testing.T) {
+	const body = "{}"
+	now := time.Now().Unix()
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set(SignatureTimestampHeader, strconv.FormatInt(now, 10))
+	req.Header.Set(SignatureHeader, sign(testSecret, now, body))
+
+	resp, err := signedApp(time.Minute).Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	got, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hmac" {
+		t.Fatalf("APIKeyID = %q, want hmac", got)
+	}
+}
+
+func TestNonceCache(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		nonce   string
+		expires time.Time
+		want    bool
+	}{
+		{"new nonce", "a", now.Add(time.Minute), true},
+		{"seen nonce", "a", now.Add(time.Minute), false},
+		{"other nonce", "b", now.Add(-time.Second), true},
+		{"expired nonce is new again", "b", now.Add(time.Minute), true},
+	}
+
+	n := newNonceCache(time.Minute)
+	for _, tt := range tests {
+		if got := n.add(tt.nonce, tt.expires); got != tt.want {
+			t.Errorf("%s: add(%q) = %t, want %t", tt.name, tt.nonce, got, tt.want)
+		}
+	}
+}
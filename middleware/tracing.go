@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"prisma-webhook/tracing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing creates a middleware that starts a server span per request, continuing
+// the caller's trace when a traceparent header is sent. The span context is stored
+// as the request's user context for the handlers further down.
+func Tracing() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		propagator := otel.GetTextMapPropagator()
+
+		carrier := propagation.MapCarrier{}
+		for _, field := range propagator.Fields() {
+			if v := c.Get(field); v != "" {
+				carrier.Set(field, v)
+			}
+		}
+
+		ctx, span := tracing.Tracer.Start(propagator.Extract(c.UserContext(), carrier), c.Method()+" "+c.Path(),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(c.Method()),
+				semconv.URLPath(c.Path()),
+				semconv.ClientAddress(ClientIP(c)),
+				semconv.UserAgentOriginal(c.Get(fiber.HeaderUserAgent)),
+			),
+		)
+		defer span.End()
+
+		c.SetUserContext(ctx)
+		err := c.Next()
+
+		// the route is only known once the handlers ran
+		span.SetName(c.Method() + " " + c.Route().Path)
+		span.SetAttributes(semconv.HTTPRoute(c.Route().Path))
+
+		status := c.Response().StatusCode()
+		if err != nil {
+			// the error handler writes the response after this returns
+			status = fiber.StatusInternalServerError
+			if e, ok := err.(*fiber.Error); ok {
+				status = e.Code
+			}
+			span.RecordError(err)
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= fiber.StatusInternalServerError {
+			span.SetStatus(codes.Error, utils.StatusMessage(status))
+		}
+
+		if id := APIKeyID(c); id != "" {
+			span.SetAttributes(semconv.EnduserID(id))
+		}
+
+		return err
+	}
+}
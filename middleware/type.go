@@ -1,14 +0,0 @@
-// package middleware
-
-// import "github.com/gofiber/fiber/v2"
-
-//	func GetWebhookType() fiber.Handler {
-//		return func(c *fiber.Ctx) error {
-//			switch c.Get("X-Type") {
-//			case "alerta":
-//				return webhook
-//			}
-//			return c.Next()
-//		}
-//	}
-package middleware
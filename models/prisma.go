@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
@@ -87,6 +88,9 @@ type CustomPrismaAlert struct {
 	PolicyType           string      `json:"policyType"`
 	AccountOwners        string      `json:"accountOwners"`
 	AccountAncestors     string      `json:"accountAncestors"`
+
+	// Raw is the alert's original JSON as received in the webhook payload
+	Raw json.RawMessage `json:"-"`
 }
 
 type Account struct {
@@ -116,6 +120,36 @@ type Metadata struct {
 	Remediable bool `json:"remediable"`
 }
 
+// ParseAlerts decodes a webhook payload holding either an array of alerts or a single alert.
+// Each alert keeps its original JSON in Raw.
+func ParseAlerts(payload []byte) ([]CustomPrismaAlert, error) {
+	var raws []json.RawMessage
+	if err := json.Unmarshal(payload, &raws); err != nil {
+		var single json.RawMessage
+		if err := json.Unmarshal(payload, &single); err != nil {
+			return nil, err
+		}
+		raws = []json.RawMessage{single}
+	}
+
+	alerts := make([]CustomPrismaAlert, 0, len(raws))
+	for _, raw := range raws {
+		var alert CustomPrismaAlert
+		if err := json.Unmarshal(raw, &alert); err != nil {
+			return nil, err
+		}
+		alert.Raw = raw
+		alerts = append(alerts, alert)
+	}
+	return alerts, nil
+}
+
+// IsTestMessage reports whether the alert is the test notification Prisma Cloud sends
+// when an integration is saved
+func (p *CustomPrismaAlert) IsTestMessage() bool {
+	return strings.HasPrefix(p.Message, "This is a test message from Prisma Cloud initiated")
+}
+
 // GetPriority maps Prisma Cloud severity to ClickUp priority
 func (p *PrismaAlert) GetPriority() int {
 	switch p.Policy.Severity {
@@ -436,22 +470,31 @@ func (p *CustomPrismaAlert) GetTaskDescriptionV2() string {
 	return desc
 }
 
-func (p *PrismaAlert) getSeverityColor(severity string) string {
-	switch strings.ToLower(severity) {
-	case "critical":
-		return "🔴 Critical" // Red
-	case "high":
-		return "🟠 High" // Red
-	case "medium":
-		return "🟡 Medium" // Orange/Yellow
-	case "low":
-		return "🟢 Low" // Green
-	default:
-		return "" // Default text color
+// GetLifecycleComment generates the task comment posted when the alert changes status
+func (p *CustomPrismaAlert) GetLifecycleComment() string {
+	comment := fmt.Sprintf("Prisma Cloud alert %s is now %s.", p.AlertId, strings.ToLower(p.AlertStatus))
+
+	if p.Reason != "" {
+		comment += "\nReason: " + p.Reason
 	}
+
+	if p.AlertDismissalNote != "" {
+		comment += "\nNote: " + p.AlertDismissalNote
+	}
+
+	return comment
+}
+
+func (p *PrismaAlert) getSeverityColor(severity string) string {
+	return SeverityEmoji(severity)
 }
 
 func (p *CustomPrismaAlert) getSeverityColor(severity string) string {
+	return SeverityEmoji(severity)
+}
+
+// SeverityEmoji returns the severity prefixed with a colored circle emoji
+func SeverityEmoji(severity string) string {
 	switch strings.ToLower(severity) {
 	case "critical":
 		return "🔴 Critical" // Red
@@ -0,0 +1,315 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"prisma-webhook/config"
+	"prisma-webhook/logging"
+	"prisma-webhook/metrics"
+	"prisma-webhook/models"
+	"prisma-webhook/services"
+	"prisma-webhook/store"
+	"prisma-webhook/tracing"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrQueueFull is returned by Enqueue when no more jobs can be buffered
+var ErrQueueFull = errors.New("job queue is full")
+
+// ErrQueueStopped is returned by Enqueue once Stop has been called
+var ErrQueueStopped = errors.New("job queue is stopped")
+
+// Queue persists webhook deliveries as jobs and processes them with a bounded worker pool
+type Queue struct {
+	store     *store.Store
+	processor *services.AlertProcessor
+	workers   int
+	retention time.Duration
+
+	// historyRetention is how long alert histories are kept; 0 keeps them
+	historyRetention time.Duration
+
+	jobs     chan string
+	draining chan struct{}
+	done     chan struct{}
+	halt     sync.Once
+	wg       sync.WaitGroup
+	mu       sync.RWMutex
+	stopped  bool
+}
+
+func NewQueue(cfg *config.Config, store *store.Store, processor *services.AlertProcessor) *Queue {
+	return &Queue{
+		store:            store,
+		processor:        processor,
+		workers:          cfg.QueueWorkers,
+		retention:        cfg.JobRetention,
+		historyRetention: cfg.HistoryRetention,
+		jobs:             make(chan string, cfg.QueueSize),
+		draining:         make(chan struct{}),
+		done:             make(chan struct{}),
+	}
+}
+
+// Start resumes jobs left over from a previous run and starts the workers
+func (q *Queue) Start() error {
+	pending, err := q.store.PendingJobs()
+	if err != nil {
+		return err
+	}
+
+	// claims of jobs interrupted by the last shutdown would make resumed jobs and
+	// re-sent alerts skip alerts that never got a ticket
+	released, err := q.store.ReleasePendingClaims()
+	if err != nil {
+		return err
+	}
+	if released > 0 {
+		slog.Info("Released alert claims of interrupted jobs", "count", released)
+	}
+
+	if len(pending) > 0 {
+		slog.Info("Resuming pending jobs", "count", len(pending))
+		go func() {
+			for _, job := range pending {
+				select {
+				case q.jobs <- job.ID:
+				case <-q.done:
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go q.worker()
+	}
+
+	go q.pruneLoop()
+
+	slog.Info("Job queue started", "workers", q.workers)
+	return nil
+}
+
+// Enqueue persists a webhook payload as a new job and schedules it for processing.
+// The job's processing continues the trace of ctx.
+func (q *Queue) Enqueue(ctx context.Context, xType string, payload []byte) (*store.Job, error) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.stopped {
+		return nil, ErrQueueStopped
+	}
+
+	job := &store.Job{
+		ID:      uuid.NewString(),
+		XType:   xType,
+		Payload: json.RawMessage(payload),
+		Status:  store.JobQueued,
+
+		RequestID:    logging.RequestID(ctx),
+		TraceContext: tracing.Inject(ctx),
+	}
+	if err := q.store.SaveJob(job); err != nil {
+		return nil, err
+	}
+
+	select {
+	case q.jobs <- job.ID:
+		return job, nil
+	default:
+		if err := q.store.DeleteJob(job.ID); err != nil {
+			slog.WarnContext(ctx, "Failed to drop rejected job", "jobId", job.ID, "error", err)
+		}
+		return nil, ErrQueueFull
+	}
+}
+
+// Depth returns the number of jobs waiting for a worker
+func (q *Queue) Depth() int {
+	return len(q.jobs)
+}
+
+// Stop stops accepting jobs and lets the workers drain the queue. When ctx ends first,
+// the running jobs are interrupted and ctx.Err() is returned; they and the jobs left
+// over stay persisted and are resumed by the next Start.
+func (q *Queue) Stop(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.stopped {
+		q.stopped = true
+		close(q.draining)
+	}
+	q.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		q.halt.Do(func() { close(q.done) })
+		return nil
+	case <-ctx.Done():
+		q.halt.Do(func() { close(q.done) })
+		return ctx.Err()
+	}
+}
+
+func (q *Queue) worker() {
+	defer q.wg.Done()
+
+	for {
+		// a halted queue starts no further jobs, even with some still waiting
+		select {
+		case <-q.done:
+			return
+		default:
+		}
+
+		select {
+		case <-q.done:
+			return
+		case id := <-q.jobs:
+			q.run(id)
+		case <-q.draining:
+			select {
+			case id := <-q.jobs:
+				q.run(id)
+			default:
+				return
+			}
+		}
+	}
+}
+
+// run processes a single job and records its outcome
+func (q *Queue) run(id string) {
+	job, err := q.store.GetJob(id)
+	if err != nil || job == nil {
+		slog.Error("Cannot load job", "jobId", id, "error", err)
+		return
+	}
+
+	job.Status = store.JobRunning
+	job.Attempts++
+
+	ctx := logging.With(tracing.Extract(job.TraceContext), "jobId", job.ID, "xType", job.XType)
+	if job.RequestID != "" {
+		ctx = logging.WithRequestID(ctx, job.RequestID)
+	}
+
+	// a shutdown whose drain deadline passed interrupts the job, e.g. in a retry backoff
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-q.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if err := q.store.SaveJob(job); err != nil {
+		slog.WarnContext(ctx, "Failed to mark job running", "error", err)
+	}
+
+	slog.InfoContext(ctx, "Running job", "attempt", job.Attempts)
+
+	ctx, span := tracing.Tracer.Start(ctx, "process job", trace.WithAttributes(
+		attribute.String("job.id", job.ID),
+		attribute.String("prisma.x_type", job.XType),
+		attribute.Int("job.attempt", job.Attempts),
+	))
+	defer span.End()
+
+	start := time.Now()
+	result, err := q.process(ctx, job)
+	metrics.JobDuration.Observe(time.Since(start).Seconds())
+	if ctx.Err() != nil {
+		// left running, so the next Start resumes it
+		slog.WarnContext(ctx, "Job interrupted by shutdown, resuming on next start", "duration", time.Since(start))
+		return
+	}
+	if err != nil {
+		job.Status = store.JobFailed
+		job.Error = err.Error()
+		tracing.Fail(span, err)
+	} else {
+		job.Status = store.JobCompleted
+		job.Error = strings.Join(result.Errors, "; ")
+		if data, err := json.Marshal(result); err == nil {
+			job.Result = data
+		}
+	}
+
+	if err := q.store.SaveJob(job); err != nil {
+		slog.ErrorContext(ctx, "Failed to save job", "error", err)
+	}
+
+	metrics.JobsProcessed.WithLabelValues(job.Status).Inc()
+	slog.InfoContext(ctx, "Finished job", "status", job.Status, "duration", time.Since(start))
+}
+
+func (q *Queue) process(ctx context.Context, job *store.Job) (result *services.ProcessResult, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic while processing job: %v", r)
+		}
+	}()
+
+	alerts, err := models.ParseAlerts(job.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse job payload: %w", err)
+	}
+
+	return q.processor.Process(ctx, job.ID, job.XType, alerts), nil
+}
+
+// pruneLoop periodically removes finished jobs, replayed dead letters and alert
+// histories older than their retention periods
+func (q *Queue) pruneLoop() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-q.draining:
+			return
+		case <-ticker.C:
+			pruned, err := q.store.PruneJobs(time.Now().Add(-q.retention))
+			if err != nil {
+				slog.Warn("Failed to prune jobs", "error", err)
+			} else if pruned > 0 {
+				slog.Info("Pruned finished jobs", "count", pruned)
+			}
+
+			pruned, err = q.store.PruneDeadLetters(time.Now().Add(-q.retention))
+			if err != nil {
+				slog.Warn("Failed to prune dead letters", "error", err)
+			} else if pruned > 0 {
+				slog.Info("Pruned replayed dead letters", "count", pruned)
+			}
+
+			if q.historyRetention > 0 {
+				pruned, err := q.store.PruneHistory(time.Now().Add(-q.historyRetention))
+				if err != nil {
+					slog.Warn("Failed to prune alert history", "error", err)
+				} else if pruned > 0 {
+					slog.Info("Pruned alert history", "count", pruned)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package routing
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// errBadPattern is returned for a malformed character class or a trailing backslash
+var errBadPattern = errors.New("syntax error in pattern")
+
+// globs caches the compiled form of each pattern seen, by pattern
+var globs sync.Map
+
+// glob returns the compiled form of a shell-style pattern
+func glob(pattern string) (*regexp.Regexp, error) {
+	if re, ok := globs.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := compileGlob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	globs.Store(pattern, re)
+	return re, nil
+}
+
+// compileGlob translates a pattern with *, ? and [...] wildcards into an anchored,
+// case-insensitive regular expression. Unlike path.Match, * and ? also match "/",
+// which account names, resource types and tag values often contain.
+func compileGlob(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("(?is)^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '\\':
+			i++
+			if i == len(pattern) {
+				return nil, errBadPattern
+			}
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		case '[':
+			end, class, err := compileClass(pattern, i)
+			if err != nil {
+				return nil, err
+			}
+			b.WriteString(class)
+			i = end
+		default:
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// compileClass translates the character class opening at pattern[start], e.g. [a-z]
+// or [^0-9], and returns the index of its closing bracket
+func compileClass(pattern string, start int) (int, string, error) {
+	var b strings.Builder
+	b.WriteString("[")
+	i := start + 1
+	if i < len(pattern) && (pattern[i] == '^' || pattern[i] == '!') {
+		b.WriteString("^")
+		i++
+	}
+	empty := true
+	for ; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case ']':
+			if empty {
+				return 0, "", errBadPattern
+			}
+			b.WriteString("]")
+			return i, b.String(), nil
+		case '\\':
+			i++
+			if i == len(pattern) {
+				return 0, "", errBadPattern
+			}
+			b.WriteString(classLiteral(pattern[i]))
+		case '-':
+			if empty || i+1 == len(pattern) || pattern[i+1] == ']' {
+				return 0, "", errBadPattern
+			}
+			b.WriteString("-")
+		case '[', '^':
+			b.WriteString(classLiteral(c))
+		default:
+			b.WriteByte(c)
+		}
+		empty = false
+	}
+	return 0, "", errBadPattern
+}
+
+// classLiteral returns c for use as a literal inside a regexp character class.
+// Punctuation is escaped; letters are not, as \d or \w would name a class.
+func classLiteral(c byte) string {
+	if c >= 0x80 || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' {
+		return string([]byte{c})
+	}
+	return `\` + string([]byte{c})
+}
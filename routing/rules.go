@@ -0,0 +1,228 @@
+package routing
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"prisma-webhook/models"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Rule is one ordered entry of the rules file: a set of match conditions and
+// the ClickUp/Teams/Slack destination used when an alert satisfies all of them
+type Rule struct {
+	Name  string `yaml:"name" json:"name"`
+	Match Match  `yaml:"match" json:"match"`
+
+	ListID          string `yaml:"listId" json:"listId,omitempty"`
+	Assignees       []int  `yaml:"assignees" json:"assignees,omitempty"`
+	Priority        int    `yaml:"priority" json:"priority,omitempty"`
+	Status          string `yaml:"status" json:"status,omitempty"`
+	TeamsWebhookURL string `yaml:"teamsWebhookUrl" json:"-"`
+	SlackWebhookURL string `yaml:"slackWebhookUrl" json:"-"`
+}
+
+// Match holds the conditions of a rule. Every non-empty condition must match;
+// within a condition any of the listed patterns may match. Patterns are
+// case-insensitive and support shell-style wildcards (*, ?, [...]), where * and ?
+// match any character including "/".
+type Match struct {
+	XType         []string          `yaml:"xType" json:"xType,omitempty"`
+	Severity      []string          `yaml:"severity" json:"severity,omitempty"`
+	CloudType     []string          `yaml:"cloudType" json:"cloudType,omitempty"`
+	AccountName   []string          `yaml:"accountName" json:"accountName,omitempty"`
+	AccountID     []string          `yaml:"accountId" json:"accountId,omitempty"`
+	PolicyLabels  []string          `yaml:"policyLabels" json:"policyLabels,omitempty"`
+	Tags          map[string]string `yaml:"tags" json:"tags,omitempty"`
+	ResourceType  []string          `yaml:"resourceType" json:"resourceType,omitempty"`
+	AlertRuleName []string          `yaml:"alertRuleName" json:"alertRuleName,omitempty"`
+}
+
+// Engine evaluates rules in file order; the first matching rule wins
+type Engine struct {
+	mu    sync.RWMutex
+	rules []Rule
+}
+
+type rulesFile struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// Load reads and validates a YAML rules file. An empty path yields an engine without rules.
+func Load(file string) (*Engine, error) {
+	if file == "" {
+		return &Engine{}, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read routing rules: %w", err)
+	}
+
+	// unknown keys are rejected so that a misspelled condition does not widen a rule
+	var rf rulesFile
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&rf); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse routing rules: %w", err)
+	}
+
+	return NewEngine(rf.Rules)
+}
+
+// NewEngine validates rules and builds an engine from them
+func NewEngine(rules []Rule) (*Engine, error) {
+	var errs []error
+	for i := range rules {
+		if rules[i].Name == "" {
+			rules[i].Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		if err := rules[i].validate(); err != nil {
+			errs = append(errs, fmt.Errorf("rule %q: %w", rules[i].Name, err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return &Engine{rules: rules}, nil
+}
+
+// Rules returns the configured rules in evaluation order
+func (e *Engine) Rules() []Rule {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.rules
+}
+
+// Replace switches to the rules of other, e.g. after the rules file was reloaded
+func (e *Engine) Replace(other *Engine) {
+	rules := other.Rules()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.rules = rules
+}
+
+// Route returns the first rule matching the alert, or nil if none matches
+func (e *Engine) Route(alert *models.CustomPrismaAlert, xType string) *Rule {
+	rules := e.Rules()
+	for i := range rules {
+		if rules[i].Match.Matches(alert, xType) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+func (r *Rule) validate() error {
+	if r.ListID == "" && len(r.Assignees) == 0 && r.Priority == 0 && r.Status == "" &&
+		r.TeamsWebhookURL == "" && r.SlackWebhookURL == "" {
+		return errors.New("rule selects nothing (set listId, assignees, priority, status, teamsWebhookUrl or slackWebhookUrl)")
+	}
+
+	if r.Match.empty() {
+		return errors.New("rule has no match conditions (use xType: [\"*\"] to match every alert)")
+	}
+
+	if r.Priority < 0 || r.Priority > 4 {
+		return fmt.Errorf("priority must be between 1 (urgent) and 4 (low), got %d", r.Priority)
+	}
+
+	return r.Match.Validate()
+}
+
+// Validate checks that every pattern of the conditions is well-formed
+func (m *Match) Validate() error {
+	patterns := [][]string{
+		m.XType, m.Severity, m.CloudType, m.AccountName, m.AccountID,
+		m.PolicyLabels, m.ResourceType, m.AlertRuleName,
+	}
+	for k, v := range m.Tags {
+		patterns = append(patterns, []string{k, v})
+	}
+	for _, list := range patterns {
+		for _, pattern := range list {
+			if _, err := glob(pattern); err != nil {
+				return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// empty reports whether the match has no conditions at all
+func (m *Match) empty() bool {
+	return len(m.XType) == 0 && len(m.Severity) == 0 && len(m.CloudType) == 0 &&
+		len(m.AccountName) == 0 && len(m.AccountID) == 0 && len(m.PolicyLabels) == 0 &&
+		len(m.Tags) == 0 && len(m.ResourceType) == 0 && len(m.AlertRuleName) == 0
+}
+
+// Matches reports whether the alert, delivered on channel xType, satisfies every condition
+func (m *Match) Matches(alert *models.CustomPrismaAlert, xType string) bool {
+	return matchAny(m.XType, xType) &&
+		matchAny(m.Severity, alert.Severity) &&
+		matchAny(m.CloudType, alert.CloudType) &&
+		matchAny(m.AccountName, alert.AccountName) &&
+		matchAny(m.AccountID, alert.AccountId) &&
+		matchAnyOf(m.PolicyLabels, alert.PolicyLabels) &&
+		matchTags(m.Tags, alert) &&
+		matchAny(m.ResourceType, alert.ResourceType) &&
+		matchAny(m.AlertRuleName, alert.AlertRuleName)
+}
+
+// matchAny reports whether value matches one of the patterns. No patterns matches everything.
+func matchAny(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if matchPattern(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAnyOf reports whether any of values matches one of the patterns
+func matchAnyOf(patterns []string, values []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, value := range values {
+		if matchAny(patterns, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchTags reports whether the alert carries every required tag key with a matching value
+func matchTags(required map[string]string, alert *models.CustomPrismaAlert) bool {
+	for key, valuePattern := range required {
+		found := false
+		for _, tag := range alert.Tags {
+			if matchPattern(key, fmt.Sprint(tag["key"])) && matchPattern(valuePattern, fmt.Sprint(tag["value"])) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// matchPattern reports whether value matches pattern; a malformed pattern matches nothing
+func matchPattern(pattern string, value string) bool {
+	re, err := glob(pattern)
+	return err == nil && re.MatchString(value)
+}
@@ -0,0 +1,160 @@
+package routing
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"prisma-webhook/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		value   string
+		want    bool
+	}{
+		{"high", "high", true},
+		{"high", "HIGH", true},
+		{"high", "highest", false},
+		{"*", "", true},
+		{"*", "anything/at all", true},
+		{"prod-*", "prod-eu", true},
+		{"prod-*", "staging-prod-eu", false},
+		{"*-prod", "eu-prod", true},
+		{"*/prod", "team-a/prod", true},
+		{"*/prod/*", "org/prod/eu/1", true},
+		{"team-a/*", "team-a/prod/eu", true},
+		{"arn:aws:iam::*:role/*", "arn:aws:iam::123456789012:role/admin/ops", true},
+		{"a?c", "a/c", true},
+		{"a?c", "ac", false},
+		{"a?c", "añc", true},
+		{"[a-c]x", "Bx", true},
+		{"[a-c]x", "dx", false},
+		{"[^a-c]x", "dx", true},
+		{"[!a-c]x", "ax", false},
+		{"[.]", ".", true},
+		{"[.]", "a", false},
+		{`[\d]`, "d", true},
+		{`[\d]`, "1", false},
+		{`a\*`, "a*", true},
+		{`a\*`, "ab", false},
+		{"a.c", "abc", false},
+		{"(prod)", "(prod)", true},
+		{"[a-", "a", false},
+	}
+
+	for _, tt := range tests {
+		if got := matchPattern(tt.pattern, tt.value); got != tt.want {
+			t.Errorf("matchPattern(%q, %q) = %t, want %t", tt.pattern, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestMatchValidate(t *testing.T) {
+	for _, pattern := range []string{"[", "[]", "[a-", "[-a]", "[a-]", `a\`, `[a\`} {
+		m := Match{AccountName: []string{pattern}}
+		if err := m.Validate(); err == nil {
+			t.Errorf("Validate(%q) = nil, want error", pattern)
+		}
+	}
+
+	m := Match{AccountName: []string{"team-*/prod", "[a-z]?"}, Tags: map[string]string{"env": "prod*"}}
+	if err := m.Validate(); err != nil {
+		t.Errorf("Validate = %v, want nil", err)
+	}
+}
+
+func writeRules(t *testing.T, content string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "rules.yaml")
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		wantRules int
+		wantErr   string
+	}{
+		{"empty file", "", 0, ""},
+		{"valid rule", "rules:\n  - match: {severity: [high]}\n    listId: \"1\"\n", 1, ""},
+		{"misspelled condition", "rules:\n  - match: {severty: [high]}\n    listId: \"1\"\n", 0, "field severty not found"},
+		{"no conditions", "rules:\n  - match: {}\n    listId: \"1\"\n", 0, "no match conditions"},
+		{"no destination", "rules:\n  - match: {severity: [high]}\n", 0, "rule selects nothing"},
+		{"bad pattern", "rules:\n  - match: {accountName: [\"[prod\"]}\n    listId: \"1\"\n", 0, "invalid pattern"},
+		{"priority out of range", "rules:\n  - match: {severity: [high]}\n    priority: 5\n", 0, "priority must be between"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine, err := Load(writeRules(t, tt.content))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Load error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(engine.Rules()); got != tt.wantRules {
+				t.Fatalf("rules = %d, want %d", got, tt.wantRules)
+			}
+		})
+	}
+}
+
+func TestRoute(t *testing.T) {
+	engine, err := Load(writeRules(t, `
+rules:
+  - name: prod-critical
+    match:
+      severity: [critical, high]
+      accountName: ["*/prod"]
+    listId: "10"
+  - name: tagged
+    match:
+      tags: {owner: "team-*"}
+    listId: "20"
+  - name: alerta
+    match:
+      xType: [alerta]
+    listId: "30"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		alert models.CustomPrismaAlert
+		xType string
+		want  string // rule name, "" for none
+	}{
+		{"account path matches", models.CustomPrismaAlert{Severity: "High", AccountName: "payments/prod"}, "mandatory", "prod-critical"},
+		{"every condition must match", models.CustomPrismaAlert{Severity: "low", AccountName: "payments/prod"}, "mandatory", ""},
+		{"tag pattern", models.CustomPrismaAlert{Tags: []fiber.Map{{"key": "Owner", "value": "team-a/ops"}}}, "mandatory", "tagged"},
+		{"first match wins", models.CustomPrismaAlert{Severity: "critical", AccountName: "a/prod"}, "alerta", "prod-critical"},
+		{"channel", models.CustomPrismaAlert{Severity: "low"}, "alerta", "alerta"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			if rule := engine.Route(&tt.alert, tt.xType); rule != nil {
+				got = rule.Name
+			}
+			if got != tt.want {
+				t.Fatalf("Route = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
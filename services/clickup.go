@@ -2,19 +2,37 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
+	"prisma-webhook/channels"
 	"prisma-webhook/config"
+	"prisma-webhook/metrics"
 	"prisma-webhook/models"
+	"prisma-webhook/routing"
+	"prisma-webhook/tracing"
+	"strings"
+	"sync"
+	"time"
 )
 
+const clickUpAPIBaseURL = "https://api.clickup.com/api/v2"
+
 type ClickUpClient struct {
-	apiToken        string
-	listAlertaID    string
-	listMandatoryID string
-	assignees       []int
+	apiToken string
+	rules    *routing.Engine
+
+	// default list, assignees and task templates per channel
+	channels *channels.Registry
+
+	// task status per Prisma alert status, used when an alert changes state;
+	// replaced by Reconfigure
+	mu                sync.RWMutex
+	lifecycleStatuses map[string]string
 }
 
 type CreateTaskRequest struct {
@@ -25,6 +43,15 @@ type CreateTaskRequest struct {
 	Status              string `json:"status,omitempty"`
 }
 
+type UpdateTaskRequest struct {
+	Status string `json:"status,omitempty"`
+}
+
+type AddCommentRequest struct {
+	CommentText string `json:"comment_text"`
+	NotifyAll   bool   `json:"notify_all"`
+}
+
 type CreateTaskResponse struct {
 	ID     string `json:"id"`
 	Name   string `json:"name"`
@@ -34,53 +61,217 @@ type CreateTaskResponse struct {
 	URL string `json:"url"`
 }
 
-func NewClickUpClient(cfg *config.Config) *ClickUpClient {
-	return &ClickUpClient{
-		apiToken:        cfg.ClickUpAPIToken,
-		listAlertaID:    cfg.ClickUpAlertaListID,
-		listMandatoryID: cfg.ClickUpMandatoryListID,
-		assignees:       cfg.ClickUpAssignees,
+func NewClickUpClient(cfg *config.Config, rules *routing.Engine, channels *channels.Registry) *ClickUpClient {
+	c := &ClickUpClient{
+		apiToken: cfg.ClickUpAPIToken,
+		rules:    rules,
+		channels: channels,
 	}
+	c.Reconfigure(cfg)
+	return c
 }
 
-func (c *ClickUpClient) CreateTask(alert *models.CustomPrismaAlert, webhookType string) (*CreateTaskResponse, error) {
-	if webhookType != "mandatory" && webhookType != "alerta" {
-		return nil, fmt.Errorf("Webhook type is invalid: %s", webhookType)
+// Reconfigure applies the lifecycle statuses of cfg, e.g. after the configuration
+// was reloaded
+func (c *ClickUpClient) Reconfigure(cfg *config.Config) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lifecycleStatuses = map[string]string{
+		"open":      cfg.ClickUpReopenStatus,
+		"resolved":  cfg.ClickUpResolvedStatus,
+		"dismissed": cfg.ClickUpDismissedStatus,
+		"snoozed":   cfg.ClickUpSnoozedStatus,
 	}
+}
 
-	listId := c.listAlertaID
-	if webhookType == "mandatory" {
-		listId = c.listMandatoryID
+func (c *ClickUpClient) CreateTask(ctx context.Context, alert *models.CustomPrismaAlert, webhookType string) (*CreateTaskResponse, error) {
+	channel := c.channels.Get(webhookType)
+	if channel == nil {
+		return nil, &PermanentError{Err: fmt.Errorf("unknown channel: %s", webhookType)}
 	}
 
-	url := fmt.Sprintf("https://api.clickup.com/api/v2/list/%s/task", listId)
+	title, err := channel.Tasks.Title(alert)
+	if err != nil {
+		return nil, &PermanentError{Err: fmt.Errorf("failed to render task title: %w", err)}
+	}
+
+	description, err := channel.Tasks.Description(alert)
+	if err != nil {
+		return nil, &PermanentError{Err: fmt.Errorf("failed to render task description: %w", err)}
+	}
 
 	taskReq := CreateTaskRequest{
-		Name:                alert.GetTaskTitle(),
-		MarkdownDescription: alert.GetTaskDescriptionV2(),
-		Assignees:           c.assignees,
+		Name:                title,
+		MarkdownDescription: description,
+		Assignees:           channel.ClickUpAssignees,
 		Priority:            alert.GetPriority(),
 		Status:              "Open",
 	}
 
-	jsonData, err := json.Marshal(taskReq)
+	listId := channel.ClickUpListID
+
+	// Routing rules override the channel defaults
+	if rule := c.rules.Route(alert, webhookType); rule != nil {
+		slog.InfoContext(ctx, "Alert matched routing rule", "rule", rule.Name)
+		if rule.ListID != "" {
+			listId = rule.ListID
+		}
+		if len(rule.Assignees) > 0 {
+			taskReq.Assignees = rule.Assignees
+		}
+		if rule.Priority != 0 {
+			taskReq.Priority = rule.Priority
+		}
+		if rule.Status != "" {
+			taskReq.Status = rule.Status
+		}
+	}
+
+	if listId == "" {
+		return nil, &PermanentError{Err: fmt.Errorf("no ClickUp list for channel %s", webhookType)}
+	}
+
+	url := fmt.Sprintf("%s/list/%s/task", clickUpAPIBaseURL, listId)
+
+	body, err := c.doRequest(ctx, "create_task", "POST", url, taskReq)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal task request: %w", err)
+		return nil, err
+	}
+
+	var taskResp CreateTaskResponse
+	if err := json.Unmarshal(body, &taskResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	return &taskResp, nil
+}
+
+// Name implements TicketSink
+func (c *ClickUpClient) Name() string {
+	return "clickup"
+}
+
+// CreateTicket implements TicketSink by creating a ClickUp task
+func (c *ClickUpClient) CreateTicket(ctx context.Context, alert *models.CustomPrismaAlert, webhookType string) (*Ticket, error) {
+	task, err := c.CreateTask(ctx, alert, webhookType)
+	if err != nil {
+		return nil, err
+	}
+	return &Ticket{ID: task.ID, URL: task.URL, Name: task.Name}, nil
+}
+
+// SyncStatus implements TicketSink by moving the task to the configured lifecycle
+// status and commenting with the reason
+func (c *ClickUpClient) SyncStatus(ctx context.Context, taskID string, alert *models.CustomPrismaAlert) error {
+	if status := c.LifecycleStatus(alert.AlertStatus); status != "" {
+		if err := c.UpdateTaskStatus(ctx, taskID, status); err != nil {
+			return err
+		}
+		slog.InfoContext(ctx, "Moved ClickUp task", "taskId", taskID, "status", status)
+	}
+
+	return c.AddComment(ctx, taskID, alert.GetLifecycleComment())
+}
+
+// LifecycleStatus returns the ClickUp status a task should move to when its
+// alert changes to alertStatus. An empty result means the status is left as is.
+func (c *ClickUpClient) LifecycleStatus(alertStatus string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.lifecycleStatuses[strings.ToLower(alertStatus)]
+}
+
+// UpdateTaskStatus moves an existing task to the given status
+func (c *ClickUpClient) UpdateTaskStatus(ctx context.Context, taskID string, status string) error {
+	url := fmt.Sprintf("%s/task/%s", clickUpAPIBaseURL, taskID)
+
+	_, err := c.doRequest(ctx, "update_status", "PUT", url, UpdateTaskRequest{Status: status})
+	return err
+}
+
+// AddComment posts a comment on an existing task
+func (c *ClickUpClient) AddComment(ctx context.Context, taskID string, text string) error {
+	url := fmt.Sprintf("%s/task/%s/comment", clickUpAPIBaseURL, taskID)
+
+	_, err := c.doRequest(ctx, "add_comment", "POST", url, AddCommentRequest{CommentText: text})
+	return err
+}
+
+// CheckHealth verifies that the API token is accepted and that every list tasks can
+// be created in, the channel defaults and the routing rules' lists, is accessible
+func (c *ClickUpClient) CheckHealth(ctx context.Context) error {
+	if _, err := c.doRequest(ctx, "get_user", "GET", clickUpAPIBaseURL+"/user", nil); err != nil {
+		return clickUpCheckError("ClickUp token rejected", err)
+	}
+
+	var lists []string
+	for _, channel := range c.channels.All() {
+		lists = append(lists, channel.ClickUpListID)
 	}
+	for _, rule := range c.rules.Rules() {
+		lists = append(lists, rule.ListID)
+	}
+
+	var errs []error
+	seen := make(map[string]bool)
+	for _, id := range lists {
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		if _, err := c.doRequest(ctx, "get_list", "GET", fmt.Sprintf("%s/list/%s", clickUpAPIBaseURL, id), nil); err != nil {
+			errs = append(errs, clickUpCheckError("ClickUp list "+id+" not accessible", err))
+		}
+	}
+	return errors.Join(errs...)
+}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+// clickUpCheckError describes an API error as msg with its status code, leaving out
+// the response body, and any other error as the API being unreachable
+func clickUpCheckError(msg string, err error) error {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return fmt.Errorf("%s (status %d)", msg, apiErr.StatusCode)
+	}
+	return fmt.Errorf("ClickUp API unreachable: %w", err)
+}
+
+// doRequest sends an authenticated JSON request to the ClickUp API and returns the response body.
+// The latency, status code and a client span are recorded under operation.
+func (c *ClickUpClient) doRequest(ctx context.Context, operation string, method string, url string, payload interface{}) ([]byte, error) {
+	var reqBody io.Reader
+	if payload != nil {
+		jsonData, err := json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request: %w", err)
+		}
+		reqBody = bytes.NewBuffer(jsonData)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Authorization", c.apiToken)
-	req.Header.Set("Content-Type", "application/json")
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
 
 	client := &http.Client{}
+	req, span := tracing.StartRequest(req, "clickup", operation)
+	start := time.Now()
 	resp, err := client.Do(req)
 	if err != nil {
+		metrics.ObserveUpstream("clickup", operation, start, 0)
+		tracing.EndRequest(span, 0, err)
 		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
+	metrics.ObserveUpstream("clickup", operation, start, resp.StatusCode)
+	tracing.EndRequest(span, resp.StatusCode, nil)
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
@@ -89,13 +280,8 @@ func (c *ClickUpClient) CreateTask(alert *models.CustomPrismaAlert, webhookType
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("ClickUp API error (status %d): %s", resp.StatusCode, string(body))
-	}
-
-	var taskResp CreateTaskResponse
-	if err := json.Unmarshal(body, &taskResp); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+		return nil, &APIError{Message: "ClickUp API error", StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
-	return &taskResp, nil
+	return body, nil
 }
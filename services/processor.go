@@ -0,0 +1,528 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"prisma-webhook/channels"
+	"prisma-webhook/logging"
+	"prisma-webhook/metrics"
+	"prisma-webhook/models"
+	"prisma-webhook/store"
+	"prisma-webhook/tracing"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// AlertProcessor turns Prisma Cloud alerts into tickets and notifications by
+// fanning out to the configured ticket sinks and notifiers
+type AlertProcessor struct {
+	channels  *channels.Registry
+	sinks     []TicketSink
+	notifiers []Notifier
+	store     *store.Store
+	retry     RetryPolicy
+}
+
+// Pipeline stages, recorded with delivery attempts and dead letters
+const (
+	StageCreateTask = "create_task"
+	StageUpdateTask = "update_task"
+	StageNotify     = "notify"
+)
+
+// ProcessResult summarizes what happened to the alerts of one webhook delivery
+type ProcessResult struct {
+	Status               string                 `json:"status"`
+	Received             int                    `json:"received"`
+	TasksCreated         int                    `json:"tasks_created"`
+	TaskIDs              []string               `json:"task_ids"`
+	TasksUpdated         int                    `json:"tasks_updated,omitempty"`
+	UpdatedTaskIDs       []string               `json:"updated_task_ids,omitempty"`
+	AlertsDeduplicated   int                    `json:"alerts_deduplicated,omitempty"`
+	DeduplicatedAlertIDs []string               `json:"deduplicated_alert_ids,omitempty"`
+	AlertsFiltered       int                    `json:"alerts_filtered,omitempty"`
+	FilteredAlertIDs     []string               `json:"filtered_alert_ids,omitempty"`
+	AlertsInProgress     int                    `json:"alerts_in_progress,omitempty"`
+	InProgressAlertIDs   []string               `json:"in_progress_alert_ids,omitempty"`
+	NotificationsSent    int                    `json:"notifications_sent,omitempty"`
+	Sinks                map[string]*SinkResult `json:"sinks,omitempty"`
+	Errors               []string               `json:"errors,omitempty"`
+}
+
+// SinkResult is the outcome for one ticket sink or notifier
+type SinkResult struct {
+	Created []string `json:"created,omitempty"`
+	URLs    []string `json:"urls,omitempty"`
+	Updated []string `json:"updated,omitempty"`
+	Sent    int      `json:"sent,omitempty"`
+	Failed  int      `json:"failed,omitempty"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+func NewAlertProcessor(
+	channels *channels.Registry,
+	sinks []TicketSink,
+	notifiers []Notifier,
+	store *store.Store,
+	retry RetryPolicy,
+) *AlertProcessor {
+	return &AlertProcessor{
+		channels:  channels,
+		sinks:     sinks,
+		notifiers: notifiers,
+		store:     store,
+		retry:     retry,
+	}
+}
+
+// Process creates or updates the tickets for each alert and sends the notifications.
+// Alerts outside the channel's filter are skipped, and alerts whose ticket cannot be
+// created or updated or whose notification cannot be sent are moved to the
+// dead-letter store. Each alert gets a span under ctx.
+func (p *AlertProcessor) Process(ctx context.Context, jobID string, xType string, alerts []models.CustomPrismaAlert) *ProcessResult {
+	result := &ProcessResult{
+		Received: len(alerts),
+		Sinks:    map[string]*SinkResult{},
+	}
+
+	// a channel removed since the delivery was queued fails in the sinks, so its
+	// alerts are dead-lettered and can be replayed
+	channel := p.channels.Get(xType)
+
+	for i := range alerts {
+		alert := &alerts[i]
+		alertCtx := logging.With(ctx, "alertId", alert.AlertId, "alertIndex", i+1)
+
+		filtered := channel != nil && !channel.Accepts(alert)
+		p.recordAlert(alertCtx, jobID, xType, alert, filtered)
+		if filtered {
+			slog.InfoContext(alertCtx, "Alert filtered out by channel", "policy", alert.PolicyName, "severity", alert.Severity)
+			result.FilteredAlertIDs = append(result.FilteredAlertIDs, alert.AlertId)
+			metrics.AlertsFiltered.WithLabelValues(xType).Inc()
+			continue
+		}
+		slog.InfoContext(alertCtx, "Processing alert", "policy", alert.PolicyName, "severity", alert.Severity, "alertStatus", alert.AlertStatus)
+
+		alertCtx, span := tracing.Tracer.Start(alertCtx, "process alert", trace.WithAttributes(
+			attribute.String("prisma.alert_id", alert.AlertId),
+			attribute.String("prisma.policy_id", alert.PolicyId),
+			attribute.String("prisma.alert_status", alert.AlertStatus),
+			attribute.String("prisma.severity", alert.Severity),
+			attribute.String("prisma.x_type", xType),
+		))
+		p.processAlert(alertCtx, jobID, xType, alert, result)
+		span.End()
+	}
+
+	result.TasksCreated = len(result.TaskIDs)
+	result.TasksUpdated = len(result.UpdatedTaskIDs)
+	result.AlertsDeduplicated = len(result.DeduplicatedAlertIDs)
+	result.AlertsFiltered = len(result.FilteredAlertIDs)
+	result.AlertsInProgress = len(result.InProgressAlertIDs)
+
+	if len(result.Errors) > 0 {
+		result.Status = "partial_success"
+	} else {
+		result.Status = "success"
+	}
+
+	return result
+}
+
+func (p *AlertProcessor) processAlert(ctx context.Context, jobID string, xType string, alert *models.CustomPrismaAlert, result *ProcessResult) {
+	span := trace.SpanFromContext(ctx)
+
+	// Step 0: Skip alerts whose tickets already exist, or sync their status. Tickets
+	// missing from an earlier delivery are then created as for a new alert.
+	var rec *store.AlertRecord
+	isNew := true
+	if alert.AlertId != "" {
+		existing, claimed, err := p.store.ClaimAlert(alert.AlertId, jobID, xType, alert.AlertStatus)
+		if err != nil {
+			result.addError(ctx, "Failed to check alert history", err)
+			tracing.Fail(span, err)
+			return
+		}
+
+		if claimed {
+			rec = &store.AlertRecord{
+				AlertID:       alert.AlertId,
+				XType:         xType,
+				Status:        alert.AlertStatus,
+				JobID:         jobID,
+				Notifications: p.pendingNotifications(alert, xType),
+			}
+		} else {
+			if existing.InProgress() && existing.JobID != jobID {
+				// another job is still creating the tickets or sending the notifications
+				// and dead-letters the alert if it fails, so this delivery is not needed
+				slog.InfoContext(ctx, "Alert is being handled by another job, skipping", "claimJobId", existing.JobID)
+				result.InProgressAlertIDs = append(result.InProgressAlertIDs, alert.AlertId)
+				return
+			}
+			statusChanged := !strings.EqualFold(existing.Status, alert.AlertStatus)
+			if statusChanged {
+				p.syncAlertStatus(ctx, jobID, xType, alert, existing, result)
+			}
+			if !p.hasMissingTickets(existing) && !existing.InProgress() {
+				if !statusChanged {
+					slog.InfoContext(ctx, "Alert already handled, skipping")
+					result.DeduplicatedAlertIDs = append(result.DeduplicatedAlertIDs, alert.AlertId)
+					metrics.AlertsDeduplicated.Inc()
+					span.SetAttributes(attribute.Bool("prisma.deduplicated", true))
+				}
+				return
+			}
+			// a sink failed or was added since an earlier delivery, or the job delivering
+			// the alert was interrupted; create only the missing tickets and send only
+			// the pending notifications
+			rec = existing
+			isNew = false
+		}
+	}
+
+	// Step 1: Create a ticket in every sink that does not have one yet
+	links := Links{PrismaURL: alert.CallbackUrl, Tickets: map[string]string{}}
+	created := 0
+	for _, sink := range p.sinks {
+		if rec != nil && rec.Tickets[sink.Name()] != nil {
+			links.Tickets[sink.Name()] = rec.Tickets[sink.Name()].URL
+			continue
+		}
+
+		var ticket *Ticket
+		sinkCtx, sinkSpan := tracing.Tracer.Start(ctx, sink.Name()+" create ticket")
+		start := time.Now()
+		attempts, err := p.attempt(sinkCtx, jobID, xType, alert, sink.Name(), StageCreateTask, func() error {
+			var err error
+			ticket, err = sink.CreateTicket(sinkCtx, alert, xType)
+			return err
+		})
+		sinkSpan.SetAttributes(attribute.Int("retry.attempts", attempts))
+		if err != nil {
+			tracing.Fail(sinkSpan, err)
+			sinkSpan.End()
+			tracing.Fail(span, err)
+			result.sinkError(ctx, sink.Name(), "Failed to create ticket for alert", err)
+			slog.WarnContext(ctx, "Giving up on ticket creation", "sink", sink.Name(), "attempts", attempts, "duration", time.Since(start))
+			p.deadLetter(ctx, jobID, xType, alert, sink.Name(), StageCreateTask, attempts, err)
+			continue
+		}
+
+		slog.InfoContext(ctx, "Created ticket", "sink", sink.Name(), "taskId", ticket.ID, "name", ticket.Name, "attempts", attempts, "duration", time.Since(start))
+		metrics.TicketsCreated.WithLabelValues(sink.Name()).Inc()
+		sinkSpan.SetAttributes(attribute.String("ticket.id", ticket.ID))
+		sinkSpan.End()
+		span.SetAttributes(attribute.String(sink.Name()+".ticket_id", ticket.ID))
+		created++
+		result.TaskIDs = append(result.TaskIDs, ticket.ID)
+		sr := result.sink(sink.Name())
+		sr.Created = append(sr.Created, ticket.ID)
+		if ticket.URL != "" {
+			sr.URLs = append(sr.URLs, ticket.URL)
+		}
+		links.Tickets[sink.Name()] = ticket.URL
+
+		if rec != nil {
+			if rec.Tickets == nil {
+				rec.Tickets = map[string]*store.TicketRef{}
+			}
+			rec.Tickets[sink.Name()] = &store.TicketRef{ID: ticket.ID, URL: ticket.URL, Status: alert.AlertStatus}
+			// record each ticket right away, so that a job interrupted before the
+			// next one does not create it again when resumed
+			if err := p.store.SaveAlert(rec); err != nil {
+				slog.WarnContext(ctx, "Failed to record tickets", "error", err)
+			}
+		}
+	}
+
+	if rec != nil {
+		if len(rec.Tickets) == 0 && len(p.sinks) > 0 {
+			// nothing was created, let a later delivery try again
+			p.releaseAlert(ctx, alert.AlertId)
+			return
+		}
+		if isNew && len(p.sinks) == 0 {
+			if err := p.store.SaveAlert(rec); err != nil {
+				slog.WarnContext(ctx, "Failed to record alert", "error", err)
+			}
+		}
+	} else if created == 0 && len(p.sinks) > 0 {
+		return
+	}
+
+	// Step 2: Send the notifications still pending, once per alert. Alerts without
+	// an ID cannot be tracked, so every delivery of them notifies.
+	for _, notifier := range p.notifiers {
+		if rec != nil && rec.Notifications[notifier.Name()] != store.NotificationPending {
+			continue
+		}
+		if !notifier.Enabled(alert, xType) {
+			// the routing changed since the alert was claimed
+			p.setNotification(ctx, rec, notifier.Name(), "")
+			continue
+		}
+
+		notifyCtx, notifySpan := tracing.Tracer.Start(ctx, notifier.Name()+" notify")
+		start := time.Now()
+		attempts, err := p.attempt(notifyCtx, jobID, xType, alert, notifier.Name(), StageNotify, func() error {
+			return notifier.Notify(notifyCtx, alert, links, xType)
+		})
+		notifySpan.SetAttributes(attribute.Int("retry.attempts", attempts))
+		if err != nil {
+			tracing.Fail(notifySpan, err)
+			notifySpan.End()
+			tracing.Fail(span, err)
+			result.sinkError(ctx, notifier.Name(), "Failed to send "+notifier.Name()+" notification", err)
+			metrics.Notifications.WithLabelValues(notifier.Name(), "failed").Inc()
+			if ctx.Err() == nil {
+				// an interrupted notification stays pending for the resumed job
+				p.setNotification(ctx, rec, notifier.Name(), store.NotificationFailed)
+			}
+			p.deadLetter(ctx, jobID, xType, alert, notifier.Name(), StageNotify, attempts, err)
+			continue
+		}
+
+		slog.InfoContext(ctx, "Sent notification", "sink", notifier.Name(), "attempts", attempts, "duration", time.Since(start))
+		metrics.Notifications.WithLabelValues(notifier.Name(), "sent").Inc()
+		notifySpan.End()
+		p.setNotification(ctx, rec, notifier.Name(), store.NotificationSent)
+		result.sink(notifier.Name()).Sent++
+		result.NotificationsSent++
+	}
+
+	// notifiers removed since the alert was claimed leave nothing to send
+	if rec != nil {
+		for name, state := range rec.Notifications {
+			if state == store.NotificationPending && p.notifier(name) == nil {
+				p.setNotification(ctx, rec, name, "")
+			}
+		}
+	}
+}
+
+// pendingNotifications returns the notifiers enabled for a new alert, all pending
+func (p *AlertProcessor) pendingNotifications(alert *models.CustomPrismaAlert, xType string) map[string]string {
+	pending := map[string]string{}
+	for _, notifier := range p.notifiers {
+		if notifier.Enabled(alert, xType) {
+			pending[notifier.Name()] = store.NotificationPending
+		}
+	}
+	return pending
+}
+
+// setNotification records the state of the alert's notification by notifier; an
+// empty state forgets it
+func (p *AlertProcessor) setNotification(ctx context.Context, rec *store.AlertRecord, notifier string, state string) {
+	if rec == nil {
+		return
+	}
+	if state == "" {
+		delete(rec.Notifications, notifier)
+	} else {
+		if rec.Notifications == nil {
+			rec.Notifications = map[string]string{}
+		}
+		rec.Notifications[notifier] = state
+	}
+	if err := p.store.SetNotification(rec.AlertID, notifier, state); err != nil {
+		slog.WarnContext(ctx, "Failed to record notification", "sink", notifier, "error", err)
+	}
+}
+
+// notifier returns the configured notifier called name, or nil
+func (p *AlertProcessor) notifier(name string) Notifier {
+	for _, notifier := range p.notifiers {
+		if notifier.Name() == name {
+			return notifier
+		}
+	}
+	return nil
+}
+
+// syncAlertStatus applies an alert status change (resolved, dismissed, snoozed, reopened)
+// to every ticket already linked to the alert
+func (p *AlertProcessor) syncAlertStatus(ctx context.Context, jobID string, xType string, alert *models.CustomPrismaAlert, rec *store.AlertRecord, result *ProcessResult) {
+	slog.InfoContext(ctx, "Alert changed status", "previousStatus", rec.Status, "alertStatus", alert.AlertStatus)
+
+	synced := true
+	for _, sink := range p.sinks {
+		ticket := rec.Tickets[sink.Name()]
+		if ticket == nil || strings.EqualFold(ticket.Status, alert.AlertStatus) {
+			continue
+		}
+
+		sinkCtx, sinkSpan := tracing.Tracer.Start(ctx, sink.Name()+" sync status", trace.WithAttributes(
+			attribute.String("ticket.id", ticket.ID),
+		))
+		start := time.Now()
+		attempts, err := p.attempt(sinkCtx, jobID, xType, alert, sink.Name(), StageUpdateTask, func() error {
+			return sink.SyncStatus(sinkCtx, ticket.ID, alert)
+		})
+		sinkSpan.SetAttributes(attribute.Int("retry.attempts", attempts))
+		if err != nil {
+			tracing.Fail(sinkSpan, err)
+			sinkSpan.End()
+			tracing.Fail(trace.SpanFromContext(ctx), err)
+			synced = false
+			result.sinkError(ctx, sink.Name(), "Failed to update ticket for alert", err)
+			p.deadLetter(ctx, jobID, xType, alert, sink.Name(), StageUpdateTask, attempts, err)
+			continue
+		}
+
+		ticket.Status = alert.AlertStatus
+		metrics.TicketsUpdated.WithLabelValues(sink.Name()).Inc()
+		sinkSpan.End()
+		slog.InfoContext(ctx, "Updated ticket", "sink", sink.Name(), "taskId", ticket.ID, "attempts", attempts, "duration", time.Since(start))
+		result.UpdatedTaskIDs = append(result.UpdatedTaskIDs, ticket.ID)
+		sr := result.sink(sink.Name())
+		sr.Updated = append(sr.Updated, ticket.ID)
+	}
+
+	// the alert status only advances once every ticket follows it, so a replay
+	// of a failed update picks up the tickets that are behind
+	if synced {
+		rec.Status = alert.AlertStatus
+	}
+	if err := p.store.SaveAlert(rec); err != nil {
+		slog.WarnContext(ctx, "Failed to record alert status", "error", err)
+	}
+}
+
+// attempt runs fn under the retry policy, recording each try as a delivery attempt
+// of the alert to sink
+func (p *AlertProcessor) attempt(ctx context.Context, jobID string, xType string, alert *models.CustomPrismaAlert, sink string, stage string, fn func() error) (int, error) {
+	n := 0
+	return p.retry.Do(ctx, sink+" "+stage, func() error {
+		n++
+		if n > 1 && (stage == StageCreateTask || stage == StageNotify) && alert.AlertId != "" {
+			// keep the claim while retrying, so other jobs do not create the ticket
+			// or send the notification too
+			if err := p.store.RefreshClaim(alert.AlertId, jobID); err != nil {
+				slog.WarnContext(ctx, "Failed to refresh alert claim", "error", err)
+			}
+		}
+		start := time.Now()
+		err := fn()
+		if alert.AlertId == "" {
+			return err
+		}
+
+		d := &store.Delivery{
+			AlertID:    alert.AlertId,
+			JobID:      jobID,
+			XType:      xType,
+			Sink:       sink,
+			Stage:      stage,
+			Attempt:    n,
+			Status:     store.DeliverySucceeded,
+			DurationMs: time.Since(start).Milliseconds(),
+		}
+		if err != nil {
+			d.Status = store.DeliveryFailed
+			d.Error = err.Error()
+		}
+		if err := p.store.AddDelivery(d); err != nil {
+			slog.WarnContext(ctx, "Failed to record delivery attempt", "sink", sink, "error", err)
+		}
+		return err
+	})
+}
+
+// recordAlert adds the received alert to its history for auditing
+func (p *AlertProcessor) recordAlert(ctx context.Context, jobID string, xType string, alert *models.CustomPrismaAlert, filtered bool) {
+	if alert.AlertId == "" {
+		return
+	}
+
+	err := p.store.RecordAlert(&store.AlertHistory{
+		AlertID:     alert.AlertId,
+		XType:       xType,
+		Severity:    alert.Severity,
+		CloudType:   alert.CloudType,
+		AccountID:   alert.AccountId,
+		AccountName: alert.AccountName,
+		PolicyID:    alert.PolicyId,
+		PolicyName:  alert.PolicyName,
+		AlertStatus: alert.AlertStatus,
+		Filtered:    filtered,
+		LastJobID:   jobID,
+		Payload:     alert.Raw,
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to record alert history", "error", err)
+	}
+}
+
+// hasMissingTickets reports whether an enabled sink has no ticket for the alert yet
+func (p *AlertProcessor) hasMissingTickets(rec *store.AlertRecord) bool {
+	for _, sink := range p.sinks {
+		if rec.Tickets[sink.Name()] == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *ProcessResult) sink(name string) *SinkResult {
+	sr, ok := r.Sinks[name]
+	if !ok {
+		sr = &SinkResult{}
+		r.Sinks[name] = sr
+	}
+	return sr
+}
+
+func (r *ProcessResult) addError(ctx context.Context, msg string, err error) {
+	slog.ErrorContext(ctx, msg, "error", err)
+	r.Errors = append(r.Errors, msg+": "+err.Error())
+}
+
+func (r *ProcessResult) sinkError(ctx context.Context, name string, msg string, err error) {
+	slog.ErrorContext(ctx, msg, "sink", name, "error", err)
+	errMsg := msg + ": " + err.Error()
+	r.Errors = append(r.Errors, errMsg)
+	sr := r.sink(name)
+	sr.Failed++
+	sr.Errors = append(sr.Errors, errMsg)
+}
+
+// releaseAlert drops the dedup claim for an alert whose tickets could not be created,
+// so a later delivery of the same alert can retry
+func (p *AlertProcessor) releaseAlert(ctx context.Context, alertID string) {
+	if alertID == "" {
+		return
+	}
+	if err := p.store.DeleteAlert(alertID); err != nil {
+		slog.WarnContext(ctx, "Failed to release alert", "error", err)
+	}
+}
+
+// deadLetter saves a failed alert delivery so an operator can replay it later
+func (p *AlertProcessor) deadLetter(ctx context.Context, jobID string, xType string, alert *models.CustomPrismaAlert, sink string, stage string, attempts int, cause error) {
+	if ctx.Err() != nil {
+		// interrupted by a shutdown; the job is resumed on the next start
+		return
+	}
+
+	err := p.store.AddDeadLetter(&store.DeadLetter{
+		JobID:    jobID,
+		AlertID:  alert.AlertId,
+		XType:    xType,
+		Sink:     sink,
+		Payload:  alert.Raw,
+		Stage:    stage,
+		Error:    cause.Error(),
+		Attempts: attempts,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to dead-letter alert", "sink", sink, "stage", stage, "error", err)
+		return
+	}
+	metrics.DeadLetters.WithLabelValues(sink, stage).Inc()
+	slog.WarnContext(ctx, "Moved alert to the dead-letter queue", "sink", sink, "stage", stage, "attempts", attempts)
+}
@@ -0,0 +1,457 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"prisma-webhook/channels"
+	"prisma-webhook/config"
+	"prisma-webhook/models"
+	"prisma-webhook/store"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// fakeSink creates numbered tickets, or fails with err if set. Like the real sinks,
+// it fails once ctx is done. after is called once a ticket was created.
+type fakeSink struct {
+	name  string
+	err   error
+	after func()
+
+	mu      sync.Mutex
+	created int
+	synced  []string
+}
+
+func (s *fakeSink) Name() string { return s.name }
+
+func (s *fakeSink) CreateTicket(ctx context.Context, alert *models.CustomPrismaAlert, _ string) (*Ticket, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.created++
+	id := fmt.Sprintf("%s-%d", s.name, s.created)
+	s.mu.Unlock()
+
+	if s.after != nil {
+		s.after()
+	}
+	return &Ticket{ID: id, URL: "https://tickets.example/" + id, Name: alert.AlertId}, nil
+}
+
+func (s *fakeSink) SyncStatus(_ context.Context, ticketID string, alert *models.CustomPrismaAlert) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.synced = append(s.synced, ticketID+"="+alert.AlertStatus)
+	return nil
+}
+
+func (s *fakeSink) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.created
+}
+
+// fakeNotifier counts the notifications it sends, or fails with err if set. Like
+// the real notifiers, it fails once ctx is done. after is called once a
+// notification was sent.
+type fakeNotifier struct {
+	name  string
+	err   error
+	after func()
+
+	mu   sync.Mutex
+	sent int
+}
+
+func (n *fakeNotifier) Name() string { return n.name }
+
+func (n *fakeNotifier) Enabled(*models.CustomPrismaAlert, string) bool { return true }
+
+func (n *fakeNotifier) Notify(ctx context.Context, _ *models.CustomPrismaAlert, _ Links, _ string) error {
+	if n.err != nil {
+		return n.err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	n.mu.Lock()
+	n.sent++
+	n.mu.Unlock()
+
+	if n.after != nil {
+		n.after()
+	}
+	return nil
+}
+
+func (n *fakeNotifier) count() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.sent
+}
+
+func openStore(t *testing.T) *store.Store {
+	t.Helper()
+	return openStoreIn(t, t.TempDir())
+}
+
+func openStoreIn(t *testing.T, dir string) *store.Store {
+	t.Helper()
+	st, err := store.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	return st
+}
+
+func newTestProcessor(t *testing.T, st *store.Store, sinks []TicketSink, notifiers []Notifier) *AlertProcessor {
+	t.Helper()
+	registry, err := channels.Load(&config.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewAlertProcessor(registry, sinks, notifiers, st, RetryPolicy{MaxAttempts: 1})
+}
+
+// delivery is a webhook delivery of a single alert
+func delivery(id string, status string) []models.CustomPrismaAlert {
+	alert := testAlert(id, "policy-1", status)
+	alert.Raw = json.RawMessage(`{"alertId":"` + id + `","alertStatus":"` + status + `"}`)
+	return []models.CustomPrismaAlert{*alert}
+}
+
+func TestProcessResumesInterruptedNotifications(t *testing.T) {
+	tests := []struct {
+		name        string
+		interrupt   string // the step after which the job is interrupted
+		wantPending map[string]string
+	}{
+		{"after the ticket", "clickup", map[string]string{"teams": store.NotificationPending, "slack": store.NotificationPending}},
+		{"after the first notification", "teams", map[string]string{"teams": store.NotificationSent, "slack": store.NotificationPending}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := openStore(t)
+			ctx, shutdown := context.WithCancel(context.Background())
+			defer shutdown()
+
+			// the shutdown arrives while the step runs, so it completes but the job
+			// stops right after it
+			interrupt := func(name string) func() {
+				return func() {
+					if name == tt.interrupt {
+						shutdown()
+					}
+				}
+			}
+			clickup := &fakeSink{name: "clickup", after: interrupt("clickup")}
+			teams := &fakeNotifier{name: "teams", after: interrupt("teams")}
+			slack := &fakeNotifier{name: "slack"}
+			p := newTestProcessor(t, st, []TicketSink{clickup}, []Notifier{teams, slack})
+
+			p.Process(ctx, "job-1", "alerta", delivery("P-1", "open"))
+
+			rec, err := st.GetAlert("P-1")
+			if err != nil || rec == nil {
+				t.Fatalf("GetAlert = %v, %v", rec, err)
+			}
+			if rec.Tickets["clickup"] == nil {
+				t.Fatal("ticket not recorded before the interruption")
+			}
+			for name, want := range tt.wantPending {
+				if got := rec.Notifications[name]; got != want {
+					t.Errorf("after interruption: %s notification = %q, want %q", name, got, want)
+				}
+			}
+
+			// the queue releases ticketless claims and resumes the job on the next start
+			if _, err := st.ReleasePendingClaims(); err != nil {
+				t.Fatal(err)
+			}
+			result := p.Process(context.Background(), "job-1", "alerta", delivery("P-1", "open"))
+
+			if result.AlertsDeduplicated != 0 {
+				t.Fatalf("resumed job deduplicated the alert: %+v", result)
+			}
+			if clickup.count() != 1 || teams.count() != 1 || slack.count() != 1 {
+				t.Fatalf("tickets = %d, teams = %d, slack = %d, want 1 each", clickup.count(), teams.count(), slack.count())
+			}
+
+			// with everything sent, a later delivery is a duplicate
+			result = p.Process(context.Background(), "job-2", "alerta", delivery("P-1", "open"))
+			if result.AlertsDeduplicated != 1 || teams.count() != 1 || slack.count() != 1 {
+				t.Fatalf("redelivery: %+v, teams = %d, slack = %d", result, teams.count(), slack.count())
+			}
+		})
+	}
+}
+
+func TestProcessDeadLettersFailedNotification(t *testing.T) {
+	st := openStore(t)
+	clickup := &fakeSink{name: "clickup"}
+	teams := &fakeNotifier{name: "teams"}
+	slack := &fakeNotifier{name: "slack", err: &PermanentError{Err: errors.New("invalid_token")}}
+	p := newTestProcessor(t, st, []TicketSink{clickup}, []Notifier{teams, slack})
+
+	result := p.Process(context.Background(), "job-1", "alerta", delivery("P-1", "open"))
+	if result.Status != "partial_success" || result.NotificationsSent != 1 {
+		t.Fatalf("result = %+v, want one notification and an error", result)
+	}
+
+	dls, err := st.ListDeadLetters(store.DeadLetterPending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dls) != 1 || dls[0].Sink != "slack" || dls[0].Stage != StageNotify || dls[0].AlertID != "P-1" {
+		t.Fatalf("dead letters = %+v, want the slack notification", dls)
+	}
+	rec, _ := st.GetAlert("P-1")
+	if got := rec.Notifications["slack"]; got != store.NotificationFailed {
+		t.Fatalf("slack notification = %q, want %q", got, store.NotificationFailed)
+	}
+
+	// a plain redelivery does not retry the failed notification
+	if result := p.Process(context.Background(), "job-2", "alerta", delivery("P-1", "open")); result.AlertsDeduplicated != 1 {
+		t.Fatalf("redelivery = %+v, want deduplicated", result)
+	}
+
+	// replaying the dead letter sends only the failed notification
+	slack.err = nil
+	if err := st.RetryNotification("P-1", "slack"); err != nil {
+		t.Fatal(err)
+	}
+	replayed, err := models.ParseAlerts(dls[0].Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result = p.Process(context.Background(), "job-3", "alerta", replayed)
+	if result.Status != "success" || result.AlertsDeduplicated != 0 {
+		t.Fatalf("replay = %+v", result)
+	}
+	if clickup.count() != 1 || teams.count() != 1 || slack.count() != 1 {
+		t.Fatalf("tickets = %d, teams = %d, slack = %d, want 1 each", clickup.count(), teams.count(), slack.count())
+	}
+}
+
+func TestProcessDeduplicatesRedelivery(t *testing.T) {
+	st := openStore(t)
+	clickup, sharepoint := &fakeSink{name: "clickup"}, &fakeSink{name: "sharepoint"}
+	teams := &fakeNotifier{name: "teams"}
+	p := newTestProcessor(t, st, []TicketSink{clickup, sharepoint}, []Notifier{teams})
+
+	first := p.Process(context.Background(), "job-1", "alerta", delivery("P-1", "open"))
+	if first.TasksCreated != 2 || first.NotificationsSent != 1 || first.AlertsDeduplicated != 0 {
+		t.Fatalf("first delivery = %+v", first)
+	}
+
+	second := p.Process(context.Background(), "job-2", "alerta", delivery("P-1", "open"))
+	if second.TasksCreated != 0 || second.NotificationsSent != 0 || second.AlertsDeduplicated != 1 ||
+		len(second.DeduplicatedAlertIDs) != 1 || second.DeduplicatedAlertIDs[0] != "P-1" {
+		t.Fatalf("redelivery = %+v", second)
+	}
+
+	// alerts without an ID cannot be recognised
+	for _, jobID := range []string{"job-3", "job-4"} {
+		if r := p.Process(context.Background(), jobID, "alerta", delivery("", "open")); r.AlertsDeduplicated != 0 {
+			t.Fatalf("%s: alert without ID deduplicated: %+v", jobID, r)
+		}
+	}
+	if clickup.count() != 3 || sharepoint.count() != 3 || teams.count() != 3 {
+		t.Fatalf("clickup = %d, sharepoint = %d, teams = %d, want 3 each", clickup.count(), sharepoint.count(), teams.count())
+	}
+}
+
+func TestProcessSkipsAlertClaimedByAnotherJob(t *testing.T) {
+	st := openStore(t)
+	clickup := &fakeSink{name: "clickup"}
+	p := newTestProcessor(t, st, []TicketSink{clickup}, nil)
+
+	// a second delivery arrives while the first job is creating the ticket
+	var overlapping *ProcessResult
+	clickup.after = func() {
+		clickup.after = nil
+		overlapping = p.Process(context.Background(), "job-2", "alerta", delivery("P-1", "open"))
+	}
+	first := p.Process(context.Background(), "job-1", "alerta", delivery("P-1", "open"))
+
+	if first.TasksCreated != 1 {
+		t.Fatalf("first delivery = %+v", first)
+	}
+	if overlapping.AlertsInProgress != 1 || overlapping.InProgressAlertIDs[0] != "P-1" || overlapping.TasksCreated != 0 {
+		t.Fatalf("overlapping delivery = %+v", overlapping)
+	}
+	if clickup.count() != 1 {
+		t.Fatalf("tickets = %d, want 1", clickup.count())
+	}
+}
+
+func TestProcessConcurrentDeliveries(t *testing.T) {
+	st := openStore(t)
+	clickup := &fakeSink{name: "clickup"}
+	teams := &fakeNotifier{name: "teams"}
+	p := newTestProcessor(t, st, []TicketSink{clickup}, []Notifier{teams})
+
+	const jobs = 8
+	results := make([]*ProcessResult, jobs)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = p.Process(context.Background(), fmt.Sprintf("job-%d", i), "alerta", delivery("P-1", "open"))
+		}()
+	}
+	wg.Wait()
+
+	created, skipped := 0, 0
+	for _, r := range results {
+		created += r.TasksCreated
+		skipped += r.AlertsDeduplicated + r.AlertsInProgress
+	}
+	if created != 1 || skipped != jobs-1 {
+		t.Fatalf("created = %d, skipped = %d, want 1 and %d", created, skipped, jobs-1)
+	}
+	if clickup.count() != 1 || teams.count() != 1 {
+		t.Fatalf("tickets = %d, notifications = %d, want 1 each", clickup.count(), teams.count())
+	}
+}
+
+func TestProcessTakesOverExpiredClaim(t *testing.T) {
+	dir := t.TempDir()
+	st := openStoreIn(t, dir)
+
+	// job-1 claimed the alert and then vanished without the queue resuming it
+	if _, claimed, err := st.ClaimAlert("P-1", "job-1", "alerta", "open"); err != nil || !claimed {
+		t.Fatalf("ClaimAlert = %t, %v", claimed, err)
+	}
+	clickup := &fakeSink{name: "clickup"}
+	p := newTestProcessor(t, st, []TicketSink{clickup}, nil)
+	if r := p.Process(context.Background(), "job-2", "alerta", delivery("P-1", "open")); r.AlertsInProgress != 1 {
+		t.Fatalf("delivery during the claim = %+v", r)
+	}
+
+	// let the claim expire by backdating it in the database file
+	st.Close()
+	db, err := bolt.Open(filepath.Join(dir, "webhook.db"), 0o600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("alerts"))
+		var rec store.AlertRecord
+		if err := json.Unmarshal(b.Get([]byte("P-1")), &rec); err != nil {
+			return err
+		}
+		rec.UpdatedAt = rec.UpdatedAt.Add(-time.Hour)
+		data, err := json.Marshal(&rec)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("P-1"), data)
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p = newTestProcessor(t, openStoreIn(t, dir), []TicketSink{clickup}, nil)
+	if r := p.Process(context.Background(), "job-3", "alerta", delivery("P-1", "open")); r.TasksCreated != 1 {
+		t.Fatalf("delivery after the claim expired = %+v", r)
+	}
+}
+
+func TestProcessResumesInterruptedTickets(t *testing.T) {
+	tests := []struct {
+		name      string
+		interrupt string // the sink after which the job is interrupted, "" for before the first
+	}{
+		{"before the first ticket", ""},
+		{"between two tickets", "clickup"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := openStore(t)
+			ctx, shutdown := context.WithCancel(context.Background())
+			defer shutdown()
+			if tt.interrupt == "" {
+				shutdown()
+			}
+
+			clickup := &fakeSink{name: "clickup"}
+			sharepoint := &fakeSink{name: "sharepoint"}
+			if tt.interrupt == "clickup" {
+				clickup.after = shutdown
+			}
+			teams := &fakeNotifier{name: "teams"}
+			p := newTestProcessor(t, st, []TicketSink{clickup, sharepoint}, []Notifier{teams})
+
+			p.Process(ctx, "job-1", "alerta", delivery("P-1", "open"))
+			if dls, _ := st.ListDeadLetters(store.DeadLetterPending); len(dls) != 0 {
+				t.Fatalf("interrupted job dead-lettered %+v", dls)
+			}
+
+			if _, err := st.ReleasePendingClaims(); err != nil {
+				t.Fatal(err)
+			}
+			clickup.after = nil
+			result := p.Process(context.Background(), "job-1", "alerta", delivery("P-1", "open"))
+
+			if result.Status != "success" || result.AlertsInProgress != 0 || result.AlertsDeduplicated != 0 {
+				t.Fatalf("resumed job = %+v", result)
+			}
+			if clickup.count() != 1 || sharepoint.count() != 1 || teams.count() != 1 {
+				t.Fatalf("clickup = %d, sharepoint = %d, teams = %d, want 1 each", clickup.count(), sharepoint.count(), teams.count())
+			}
+		})
+	}
+}
+
+func TestProcessStatusChangeCreatesMissingTickets(t *testing.T) {
+	st := openStore(t)
+	clickup := &fakeSink{name: "clickup"}
+	teams := &fakeNotifier{name: "teams"}
+	p := newTestProcessor(t, st, []TicketSink{clickup}, []Notifier{teams})
+	p.Process(context.Background(), "job-1", "alerta", delivery("P-1", "open"))
+
+	// SharePoint was enabled before the alert was resolved
+	sharepoint := &fakeSink{name: "sharepoint"}
+	p = newTestProcessor(t, st, []TicketSink{clickup, sharepoint}, []Notifier{teams})
+	result := p.Process(context.Background(), "job-2", "alerta", delivery("P-1", "resolved"))
+
+	if result.Status != "success" || result.TasksUpdated != 1 || result.TasksCreated != 1 {
+		t.Fatalf("status change = %+v, want the ClickUp task updated and a SharePoint page created", result)
+	}
+	if len(clickup.synced) != 1 || clickup.synced[0] != "clickup-1=resolved" {
+		t.Fatalf("clickup synced %v", clickup.synced)
+	}
+	if sharepoint.count() != 1 || teams.count() != 1 {
+		t.Fatalf("sharepoint = %d, teams = %d, want 1 each", sharepoint.count(), teams.count())
+	}
+
+	rec, err := st.GetAlert("P-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Status != "resolved" || rec.Tickets["sharepoint"] == nil || rec.Tickets["sharepoint"].Status != "resolved" {
+		t.Fatalf("record = %+v", rec)
+	}
+
+	if result := p.Process(context.Background(), "job-3", "alerta", delivery("P-1", "resolved")); result.AlertsDeduplicated != 1 {
+		t.Fatalf("redelivery = %+v, want deduplicated", result)
+	}
+}
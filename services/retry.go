@@ -0,0 +1,102 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"prisma-webhook/config"
+	"time"
+)
+
+// APIError is returned when an upstream API answers with an unexpected status code
+type APIError struct {
+	Message    string
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s (status %d): %s", e.Message, e.StatusCode, e.Body)
+}
+
+// PermanentError marks a failure that retrying cannot fix, such as a template error
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// IsRetryable reports whether err is worth retrying. Upstream 4xx answers other
+// than 429 mean the request itself is wrong and will fail again.
+func IsRetryable(err error) bool {
+	var permErr *PermanentError
+	if errors.As(err, &permErr) {
+		return false
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= 500 || apiErr.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// RetryPolicy retries failed upstream calls with exponential backoff and full jitter
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+func NewRetryPolicy(cfg *config.Config) RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: cfg.RetryMaxAttempts,
+		BaseDelay:   cfg.RetryBaseDelay,
+		MaxDelay:    cfg.RetryMaxDelay,
+	}
+}
+
+// Do calls fn until it succeeds, fails with a non-retryable error or runs out of attempts.
+// It returns the number of attempts made and the last error. Retries are logged with ctx,
+// and the wait between attempts ends early with ctx.Err() when ctx is done.
+func (p RetryPolicy) Do(ctx context.Context, op string, fn func() error) (int, error) {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return attempt, nil
+		}
+
+		if attempt >= p.MaxAttempts || !IsRetryable(err) {
+			return attempt, err
+		}
+
+		delay := p.backoff(attempt)
+		slog.WarnContext(ctx, "Upstream call failed, retrying", "op", op, "attempt", attempt, "maxAttempts", p.MaxAttempts, "delay", delay, "error", err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return attempt, ctx.Err()
+		}
+	}
+}
+
+// backoff returns a random delay between zero and BaseDelay*2^(attempt-1), capped at MaxDelay
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay << (attempt - 1)
+	if delay <= 0 || delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int64N(int64(delay) + 1))
+}
@@ -0,0 +1,464 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"prisma-webhook/config"
+	"prisma-webhook/metrics"
+	"prisma-webhook/models"
+	"prisma-webhook/templates"
+	"prisma-webhook/tracing"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SharePoint page modes
+const (
+	SharePointPagePerAlert  = "alert"
+	SharePointPagePerPolicy = "policy"
+)
+
+// SharePointClient publishes a SharePoint site page per alert (or per policy)
+// through Microsoft Graph, authenticating with the OAuth client-credentials flow
+type SharePointClient struct {
+	tenantID     string
+	clientID     string
+	clientSecret string
+	siteID       string
+	pageMode     string
+	graphURL     string
+	authorityURL string
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+	siteWebURL  string
+
+	// serializes lookup and creation of pages, so a page is never created twice
+	pageMu sync.Mutex
+}
+
+type graphTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+type graphSite struct {
+	WebURL string `json:"webUrl"`
+}
+
+type graphSitePage struct {
+	ID              string                `json:"id"`
+	Name            string                `json:"name"`
+	Title           string                `json:"title"`
+	WebURL          string                `json:"webUrl"`
+	PublishingState *graphPublishingState `json:"publishingState,omitempty"`
+}
+
+type graphPublishingState struct {
+	Level string `json:"level"`
+}
+
+// published reports whether the page's latest version is visible to site readers
+func (p *graphSitePage) published() bool {
+	return p.PublishingState != nil && strings.EqualFold(p.PublishingState.Level, "published")
+}
+
+type graphSitePageList struct {
+	Value []graphSitePage `json:"value"`
+}
+
+// SitePageRequest is the body of a Graph sitePage create or update
+type SitePageRequest struct {
+	ODataType    string               `json:"@odata.type"`
+	Name         string               `json:"name,omitempty"`
+	Title        string               `json:"title"`
+	PageLayout   string               `json:"pageLayout,omitempty"`
+	ShowComments bool                 `json:"showComments"`
+	CanvasLayout sitePageCanvasLayout `json:"canvasLayout"`
+}
+
+type sitePageCanvasLayout struct {
+	HorizontalSections []sitePageSection `json:"horizontalSections"`
+}
+
+type sitePageSection struct {
+	ID       string           `json:"id"`
+	Layout   string           `json:"layout"`
+	Emphasis string           `json:"emphasis"`
+	Columns  []sitePageColumn `json:"columns"`
+}
+
+type sitePageColumn struct {
+	ID       string            `json:"id"`
+	Width    int               `json:"width"`
+	Webparts []sitePageWebPart `json:"webparts"`
+}
+
+type sitePageWebPart struct {
+	ODataType string `json:"@odata.type"`
+	InnerHTML string `json:"innerHtml"`
+}
+
+// sitePageBody is the alert detail shown on the page
+var sitePageBody = template.Must(template.New("sitepage").Parse(`<h2>{{.severityEmoji}} {{.policyName}}</h2>
+<p><strong>Status:</strong> {{.alertStatus}}<br>
+<strong>Alert ID:</strong> {{.alertId}}<br>
+<strong>Alert Time:</strong> {{.alertTime}}</p>
+<h3>Resource</h3>
+<p><strong>Resource:</strong> {{.resourceName}} ({{.resourceType}})<br>
+<strong>Resource ID:</strong> {{.resourceId}}<br>
+<strong>Account:</strong> {{.accountName}} ({{.accountId}})<br>
+<strong>Cloud:</strong> {{.cloudType}}<br>
+<strong>Region:</strong> {{.region}}</p>
+{{with .alert.policyDescription}}<h3>Description</h3>
+<p>{{.}}</p>
+{{end}}{{with .alert.policyRecommendation}}<h3>Recommendation</h3>
+<p>{{.}}</p>
+{{end}}{{with .tags}}<h3>Tags</h3>
+<p>{{.}}</p>
+{{end}}{{with .prismaUrl}}<p><a href="{{.}}">View Prisma Detail Alert</a></p>
+{{end}}`))
+
+// pageNameUnsafe matches the characters not allowed in a page file name
+var pageNameUnsafe = regexp.MustCompile(`[^A-Za-z0-9-]+`)
+
+func NewSharePointClient(cfg *config.Config) *SharePointClient {
+	return &SharePointClient{
+		tenantID:     cfg.AzureTenantID,
+		clientID:     cfg.AzureClientID,
+		clientSecret: cfg.AzureClientSecret,
+		siteID:       cfg.SharePointSiteID,
+		pageMode:     cfg.SharePointPageMode,
+		graphURL:     strings.TrimRight(cfg.GraphBaseURL, "/"),
+		authorityURL: strings.TrimRight(cfg.AzureAuthorityURL, "/"),
+	}
+}
+
+// Name implements TicketSink
+func (s *SharePointClient) Name() string {
+	return "sharepoint"
+}
+
+// CreateTicket implements TicketSink by publishing the alert's site page.
+// An existing page of the same name is reused: in policy mode the page of the
+// alert's policy, and in alert mode the page of an earlier attempt whose publish
+// failed, which is then only published.
+func (s *SharePointClient) CreateTicket(ctx context.Context, alert *models.CustomPrismaAlert, webhookType string) (*Ticket, error) {
+	name := s.pageName(alert)
+
+	s.pageMu.Lock()
+	defer s.pageMu.Unlock()
+
+	existing, err := s.findPage(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		if !existing.published() {
+			slog.InfoContext(ctx, "Publishing SharePoint page left unpublished", "page", existing.Name)
+			if err := s.publish(ctx, existing.ID); err != nil {
+				return nil, err
+			}
+		} else if s.pageMode == SharePointPagePerPolicy {
+			slog.InfoContext(ctx, "Reusing SharePoint page for policy", "page", existing.Name, "policyId", alert.PolicyId)
+		}
+		return s.ticket(ctx, existing)
+	}
+
+	req, err := s.pageRequest(alert, webhookType)
+	if err != nil {
+		return nil, err
+	}
+	req.Name = name
+	req.PageLayout = "article"
+
+	var page graphSitePage
+	if err := s.doRequest(ctx, "create_page", "POST", s.sitePath("/pages"), req, &page); err != nil {
+		return nil, err
+	}
+
+	if err := s.publish(ctx, page.ID); err != nil {
+		return nil, err
+	}
+
+	return s.ticket(ctx, &page)
+}
+
+// SyncStatus implements TicketSink by re-rendering a per-alert page with the new
+// alert status. Per-policy pages are shared between alerts and left unchanged.
+func (s *SharePointClient) SyncStatus(ctx context.Context, pageID string, alert *models.CustomPrismaAlert) error {
+	if s.pageMode == SharePointPagePerPolicy {
+		return nil
+	}
+
+	req, err := s.pageRequest(alert, "")
+	if err != nil {
+		return err
+	}
+
+	if err := s.doRequest(ctx, "update_page", "PATCH", s.sitePath("/pages/"+pageID+"/microsoft.graph.sitePage"), req, nil); err != nil {
+		return err
+	}
+
+	return s.publish(ctx, pageID)
+}
+
+// pageName returns the file name of the page holding the alert. It is the same for
+// every attempt, so a retry finds the page created by an earlier one.
+func (s *SharePointClient) pageName(alert *models.CustomPrismaAlert) string {
+	key := "alert-" + alert.AlertId
+	if s.pageMode == SharePointPagePerPolicy {
+		key = "policy-" + alert.PolicyId
+	} else if alert.AlertId == "" {
+		sum := sha256.Sum256(alert.Raw)
+		key = "alert-" + hex.EncodeToString(sum[:8])
+	}
+
+	key = strings.Trim(pageNameUnsafe.ReplaceAllString(key, "-"), "-")
+	return "prisma-" + key + ".aspx"
+}
+
+// pageRequest renders the page title and content for the alert
+func (s *SharePointClient) pageRequest(alert *models.CustomPrismaAlert, webhookType string) (*SitePageRequest, error) {
+	data := templates.CardData(alert, "", alert.CallbackUrl, webhookType)
+	data["tags"] = formatTags(alert)
+
+	var body bytes.Buffer
+	if err := sitePageBody.Execute(&body, data); err != nil {
+		return nil, &PermanentError{Err: fmt.Errorf("failed to render SharePoint page: %w", err)}
+	}
+
+	title := fmt.Sprintf("[%s] %s", strings.ToUpper(alert.Severity), alert.PolicyName)
+	if s.pageMode == SharePointPagePerPolicy {
+		title = "Prisma Cloud policy: " + alert.PolicyName
+	}
+
+	return &SitePageRequest{
+		ODataType:    "#microsoft.graph.sitePage",
+		Title:        title,
+		ShowComments: true,
+		CanvasLayout: sitePageCanvasLayout{
+			HorizontalSections: []sitePageSection{
+				{
+					ID:       "1",
+					Layout:   "oneColumn",
+					Emphasis: "none",
+					Columns: []sitePageColumn{
+						{
+							ID:    "1",
+							Width: 12,
+							Webparts: []sitePageWebPart{
+								{ODataType: "#microsoft.graph.textWebPart", InnerHTML: body.String()},
+							},
+						},
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+// findPage returns the site page with the given file name, or nil if there is none
+func (s *SharePointClient) findPage(ctx context.Context, name string) (*graphSitePage, error) {
+	filter := url.QueryEscape(fmt.Sprintf("name eq '%s'", name))
+	path := s.sitePath("/pages/microsoft.graph.sitePage?$select=id,name,title,webUrl,publishingState&$filter=" + filter)
+
+	var list graphSitePageList
+	if err := s.doRequest(ctx, "find_page", "GET", path, nil, &list); err != nil {
+		return nil, err
+	}
+
+	for i := range list.Value {
+		if strings.EqualFold(list.Value[i].Name, name) {
+			return &list.Value[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// publish makes the latest version of a page visible to site readers
+func (s *SharePointClient) publish(ctx context.Context, pageID string) error {
+	return s.doRequest(ctx, "publish_page", "POST", s.sitePath("/pages/"+pageID+"/microsoft.graph.sitePage/publish"), nil, nil)
+}
+
+// ticket converts a page to a Ticket with an absolute page URL
+func (s *SharePointClient) ticket(ctx context.Context, page *graphSitePage) (*Ticket, error) {
+	pageURL, err := s.absoluteURL(ctx, page.WebURL)
+	if err != nil {
+		return nil, err
+	}
+	return &Ticket{ID: page.ID, URL: pageURL, Name: page.Title}, nil
+}
+
+// absoluteURL resolves a page webUrl, which Graph may return relative to the site
+func (s *SharePointClient) absoluteURL(ctx context.Context, webURL string) (string, error) {
+	if webURL == "" || strings.HasPrefix(webURL, "http://") || strings.HasPrefix(webURL, "https://") {
+		return webURL, nil
+	}
+
+	s.mu.Lock()
+	siteWebURL := s.siteWebURL
+	s.mu.Unlock()
+
+	if siteWebURL == "" {
+		var site graphSite
+		if err := s.doRequest(ctx, "get_site", "GET", s.sitePath("?$select=webUrl"), nil, &site); err != nil {
+			return "", err
+		}
+		siteWebURL = site.WebURL
+
+		s.mu.Lock()
+		s.siteWebURL = siteWebURL
+		s.mu.Unlock()
+	}
+
+	return strings.TrimRight(siteWebURL, "/") + "/" + strings.TrimLeft(webURL, "/"), nil
+}
+
+func (s *SharePointClient) sitePath(path string) string {
+	return fmt.Sprintf("%s/sites/%s%s", s.graphURL, s.siteID, path)
+}
+
+// accessToken returns a cached Graph token, requesting a new one shortly before it expires
+func (s *SharePointClient) accessToken(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && time.Now().Before(s.tokenExpiry) {
+		return s.token, nil
+	}
+
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {s.clientID},
+		"client_secret": {s.clientSecret},
+		"scope":         {"https://graph.microsoft.com/.default"},
+	}
+	tokenURL := fmt.Sprintf("%s/%s/oauth2/v2.0/token", s.authorityURL, s.tenantID)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client := &http.Client{}
+	req, span := tracing.StartRequest(req, "sharepoint", "token")
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		metrics.ObserveUpstream("sharepoint", "token", start, 0)
+		tracing.EndRequest(span, 0, err)
+		return "", fmt.Errorf("failed to request Graph token: %w", err)
+	}
+	metrics.ObserveUpstream("sharepoint", "token", start, resp.StatusCode)
+	tracing.EndRequest(span, resp.StatusCode, nil)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read token response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", &APIError{Message: "Azure AD token request failed", StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	var tokenResp graphTokenResponse
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return "", fmt.Errorf("failed to unmarshal token response: %w", err)
+	}
+	if tokenResp.AccessToken == "" {
+		return "", fmt.Errorf("token response has no access_token")
+	}
+
+	// renew a minute early so a request never goes out with an expired token
+	s.token = tokenResp.AccessToken
+	s.tokenExpiry = time.Now().Add(time.Duration(tokenResp.ExpiresIn)*time.Second - time.Minute)
+	return s.token, nil
+}
+
+// doRequest sends an authenticated JSON request to Microsoft Graph and decodes the
+// response into out (if not nil). A rejected token is renewed once. The latency and
+// status code are recorded under operation, with a client span per call.
+func (s *SharePointClient) doRequest(ctx context.Context, operation string, method string, url string, payload interface{}, out interface{}) error {
+	var jsonData []byte
+	if payload != nil {
+		var err error
+		jsonData, err = json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		token, err := s.accessToken(ctx)
+		if err != nil {
+			return err
+		}
+
+		var reqBody io.Reader
+		if payload != nil {
+			reqBody = bytes.NewReader(jsonData)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+		if err != nil {
+			return fmt.Errorf("failed to create request: %w", err)
+		}
+
+		req.Header.Set("Authorization", "Bearer "+token)
+		if payload != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		client := &http.Client{}
+		req, span := tracing.StartRequest(req, "sharepoint", operation)
+		start := time.Now()
+		resp, err := client.Do(req)
+		if err != nil {
+			metrics.ObserveUpstream("sharepoint", operation, start, 0)
+			tracing.EndRequest(span, 0, err)
+			return fmt.Errorf("failed to send request: %w", err)
+		}
+		metrics.ObserveUpstream("sharepoint", operation, start, resp.StatusCode)
+		tracing.EndRequest(span, resp.StatusCode, nil)
+
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read response: %w", err)
+		}
+
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 1 {
+			s.mu.Lock()
+			s.token = ""
+			s.mu.Unlock()
+			continue
+		}
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return &APIError{Message: "Microsoft Graph API error", StatusCode: resp.StatusCode, Body: string(body)}
+		}
+
+		if out != nil && len(body) > 0 {
+			if err := json.Unmarshal(body, out); err != nil {
+				return fmt.Errorf("failed to unmarshal response: %w", err)
+			}
+		}
+
+		return nil
+	}
+}
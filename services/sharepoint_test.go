@@ -0,0 +1,359 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"prisma-webhook/config"
+	"prisma-webhook/models"
+)
+
+// fakeGraph is an in-memory stand-in for the Azure AD token endpoint and the
+// Microsoft Graph site page API used by SharePointClient
+type fakeGraph struct {
+	t *testing.T
+
+	mu    sync.Mutex
+	pages map[string]*fakePage // by ID
+	calls []string
+
+	// failPublish makes the next publish calls fail with 503
+	failPublish int
+}
+
+type fakePage struct {
+	graphSitePage
+	Body      SitePageRequest
+	Published bool
+}
+
+func newFakeGraph(t *testing.T) (*fakeGraph, *httptest.Server) {
+	g := &fakeGraph{t: t, pages: map[string]*fakePage{}}
+	srv := httptest.NewServer(g)
+	t.Cleanup(srv.Close)
+	return g, srv
+}
+
+func (g *fakeGraph) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	path := r.URL.Path
+	g.calls = append(g.calls, r.Method+" "+path)
+
+	if r.Method == "POST" && path == "/tenant/oauth2/v2.0/token" {
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("client_secret") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		writeJSON(w, map[string]any{"access_token": "token", "expires_in": 3600})
+		return
+	}
+
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	const site = "/sites/site"
+	switch {
+	case r.Method == "GET" && path == site:
+		writeJSON(w, map[string]any{"webUrl": "https://contoso.sharepoint.com/sites/sec"})
+
+	case r.Method == "GET" && path == site+"/pages/microsoft.graph.sitePage":
+		name := strings.TrimSuffix(strings.TrimPrefix(r.URL.Query().Get("$filter"), "name eq '"), "'")
+		list := []graphSitePage{}
+		for _, p := range g.pages {
+			if p.Name == name {
+				list = append(list, p.view())
+			}
+		}
+		writeJSON(w, map[string]any{"value": list})
+
+	case r.Method == "POST" && path == site+"/pages":
+		var req SitePageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for _, p := range g.pages {
+			if p.Name == req.Name {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+		}
+		id := fmt.Sprintf("page-%d", len(g.pages)+1)
+		p := &fakePage{
+			graphSitePage: graphSitePage{ID: id, Name: req.Name, Title: req.Title, WebURL: "SitePages/" + req.Name},
+			Body:          req,
+		}
+		g.pages[id] = p
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, p.view())
+
+	case r.Method == "PATCH" && strings.HasSuffix(path, "/microsoft.graph.sitePage"):
+		p := g.page(path, "/microsoft.graph.sitePage")
+		if p == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&p.Body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		p.Title = p.Body.Title
+		p.Published = false
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == "POST" && strings.HasSuffix(path, "/microsoft.graph.sitePage/publish"):
+		p := g.page(path, "/microsoft.graph.sitePage/publish")
+		if p == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if g.failPublish > 0 {
+			g.failPublish--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		p.Published = true
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		g.t.Errorf("unexpected Graph request %s %s", r.Method, r.URL)
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// page returns the page whose ID is in path between "/pages/" and suffix
+func (g *fakeGraph) page(path string, suffix string) *fakePage {
+	_, rest, _ := strings.Cut(path, "/pages/")
+	return g.pages[strings.TrimSuffix(rest, suffix)]
+}
+
+// count returns how often the request "METHOD /path" was made
+func (g *fakeGraph) count(call string) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	n := 0
+	for _, c := range g.calls {
+		if c == call {
+			n++
+		}
+	}
+	return n
+}
+
+func (p *fakePage) view() graphSitePage {
+	v := p.graphSitePage
+	level := "checkout"
+	if p.Published {
+		level = "published"
+	}
+	v.PublishingState = &graphPublishingState{Level: level}
+	return v
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func newTestSharePoint(srvURL string, mode string) *SharePointClient {
+	return NewSharePointClient(&config.Config{
+		AzureTenantID:      "tenant",
+		AzureClientID:      "client",
+		AzureClientSecret:  "secret",
+		SharePointSiteID:   "site",
+		SharePointPageMode: mode,
+		GraphBaseURL:       srvURL,
+		AzureAuthorityURL:  srvURL,
+	})
+}
+
+func testAlert(id string, policyID string, status string) *models.CustomPrismaAlert {
+	return &models.CustomPrismaAlert{
+		AlertId:     id,
+		PolicyId:    policyID,
+		PolicyName:  "S3 bucket is public",
+		Severity:    "high",
+		AlertStatus: status,
+		Raw:         json.RawMessage(`{"alertId":"` + id + `"}`),
+	}
+}
+
+func TestSharePointCreateTicketPerAlert(t *testing.T) {
+	g, srv := newFakeGraph(t)
+	sp := newTestSharePoint(srv.URL, SharePointPagePerAlert)
+	ctx := context.Background()
+
+	first, err := sp.CreateTicket(ctx, testAlert("P-1", "pol-1", "open"), "alerta")
+	if err != nil {
+		t.Fatalf("CreateTicket: %v", err)
+	}
+	second, err := sp.CreateTicket(ctx, testAlert("P-2", "pol-1", "open"), "alerta")
+	if err != nil {
+		t.Fatalf("CreateTicket: %v", err)
+	}
+
+	if first.ID == second.ID {
+		t.Errorf("alerts share page %s, want one page per alert", first.ID)
+	}
+	if want := "https://contoso.sharepoint.com/sites/sec/SitePages/prisma-alert-P-1.aspx"; first.URL != want {
+		t.Errorf("URL = %q, want %q", first.URL, want)
+	}
+	if first.Name != "[HIGH] S3 bucket is public" {
+		t.Errorf("Name = %q", first.Name)
+	}
+	for _, p := range g.pages {
+		if !p.Published {
+			t.Errorf("page %s was not published", p.Name)
+		}
+		if !strings.Contains(p.Body.CanvasLayout.HorizontalSections[0].Columns[0].Webparts[0].InnerHTML, "S3 bucket is public") {
+			t.Errorf("page %s does not show the policy", p.Name)
+		}
+	}
+}
+
+func TestSharePointCreateTicketReusesPolicyPage(t *testing.T) {
+	g, srv := newFakeGraph(t)
+	sp := newTestSharePoint(srv.URL, SharePointPagePerPolicy)
+	ctx := context.Background()
+
+	first, err := sp.CreateTicket(ctx, testAlert("P-1", "pol-1", "open"), "alerta")
+	if err != nil {
+		t.Fatalf("CreateTicket: %v", err)
+	}
+	second, err := sp.CreateTicket(ctx, testAlert("P-2", "pol-1", "open"), "alerta")
+	if err != nil {
+		t.Fatalf("CreateTicket: %v", err)
+	}
+	other, err := sp.CreateTicket(ctx, testAlert("P-3", "pol-2", "open"), "alerta")
+	if err != nil {
+		t.Fatalf("CreateTicket: %v", err)
+	}
+
+	if first.ID != second.ID {
+		t.Errorf("alerts of one policy got pages %s and %s, want one", first.ID, second.ID)
+	}
+	if other.ID == first.ID {
+		t.Errorf("alerts of different policies share page %s", first.ID)
+	}
+	if n := g.count("POST /sites/site/pages"); n != 2 {
+		t.Errorf("created pages %d times, want 2", n)
+	}
+	if len(g.pages) != 2 {
+		t.Errorf("created %d pages, want 2", len(g.pages))
+	}
+	if first.Name != "Prisma Cloud policy: S3 bucket is public" {
+		t.Errorf("Name = %q", first.Name)
+	}
+}
+
+func TestSharePointCreateTicketRetriesOnlyPublish(t *testing.T) {
+	for _, mode := range []string{SharePointPagePerAlert, SharePointPagePerPolicy} {
+		t.Run(mode, func(t *testing.T) {
+			g, srv := newFakeGraph(t)
+			sp := newTestSharePoint(srv.URL, mode)
+			ctx := context.Background()
+			alert := testAlert("P-1", "pol-1", "open")
+
+			g.failPublish = 1
+			_, err := sp.CreateTicket(ctx, alert, "alerta")
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+				t.Fatalf("first attempt error = %v, want the publish failure", err)
+			}
+			if !IsRetryable(err) {
+				t.Errorf("publish failure is not retryable: %v", err)
+			}
+
+			ticket, err := sp.CreateTicket(ctx, alert, "alerta")
+			if err != nil {
+				t.Fatalf("retry: %v", err)
+			}
+
+			if n := g.count("POST /sites/site/pages"); n != 1 {
+				t.Fatalf("created pages %d times, want 1", n)
+			}
+			if page := g.pages[ticket.ID]; page == nil || !page.Published {
+				t.Errorf("page %s not published by the retry", ticket.ID)
+			}
+			if n := g.count("POST /sites/site/pages/" + ticket.ID + "/microsoft.graph.sitePage/publish"); n != 2 {
+				t.Errorf("publish called %d times, want 2", n)
+			}
+		})
+	}
+}
+
+func TestSharePointPageNameWithoutAlertID(t *testing.T) {
+	sp := newTestSharePoint("http://graph.invalid", SharePointPagePerAlert)
+	alert := &models.CustomPrismaAlert{Raw: json.RawMessage(`{"policyName":"x"}`)}
+
+	name := sp.pageName(alert)
+	if name != sp.pageName(alert) {
+		t.Errorf("page name changes between attempts")
+	}
+	if other := sp.pageName(&models.CustomPrismaAlert{Raw: json.RawMessage(`{"policyName":"y"}`)}); other == name {
+		t.Errorf("different alerts share page name %s", name)
+	}
+}
+
+func TestSharePointSyncStatus(t *testing.T) {
+	tests := []struct {
+		mode        string
+		wantUpdated bool
+	}{
+		{SharePointPagePerAlert, true},
+		{SharePointPagePerPolicy, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			g, srv := newFakeGraph(t)
+			sp := newTestSharePoint(srv.URL, tt.mode)
+			ctx := context.Background()
+
+			ticket, err := sp.CreateTicket(ctx, testAlert("P-1", "pol-1", "open"), "alerta")
+			if err != nil {
+				t.Fatalf("CreateTicket: %v", err)
+			}
+			if err := sp.SyncStatus(ctx, ticket.ID, testAlert("P-1", "pol-1", "resolved")); err != nil {
+				t.Fatalf("SyncStatus: %v", err)
+			}
+
+			page := g.pages[ticket.ID]
+			html := page.Body.CanvasLayout.HorizontalSections[0].Columns[0].Webparts[0].InnerHTML
+			if updated := strings.Contains(html, "resolved"); updated != tt.wantUpdated {
+				t.Errorf("page shows resolved = %v, want %v", updated, tt.wantUpdated)
+			}
+			if !page.Published {
+				t.Errorf("page left unpublished")
+			}
+		})
+	}
+}
+
+func TestSharePointRenewsRejectedToken(t *testing.T) {
+	g, srv := newFakeGraph(t)
+	sp := newTestSharePoint(srv.URL, SharePointPagePerAlert)
+
+	// a token the server no longer accepts is renewed once
+	sp.token = "stale"
+	sp.tokenExpiry = sp.tokenExpiry.AddDate(100, 0, 0)
+
+	if _, err := sp.CreateTicket(context.Background(), testAlert("P-1", "pol-1", "open"), "alerta"); err != nil {
+		t.Fatalf("CreateTicket: %v", err)
+	}
+	if n := g.count("POST /tenant/oauth2/v2.0/token"); n != 1 {
+		t.Errorf("token requested %d times, want 1", n)
+	}
+}
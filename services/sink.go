@@ -0,0 +1,46 @@
+package services
+
+import (
+	"context"
+	"prisma-webhook/models"
+)
+
+// Ticket is the record a TicketSink created for an alert
+type Ticket struct {
+	ID   string
+	URL  string
+	Name string
+}
+
+// TicketSink creates and maintains one ticket per alert, e.g. a ClickUp task
+type TicketSink interface {
+	// Name identifies the sink in the store, responses and logs
+	Name() string
+
+	// CreateTicket creates the ticket for a new alert
+	CreateTicket(ctx context.Context, alert *models.CustomPrismaAlert, webhookType string) (*Ticket, error)
+
+	// SyncStatus applies an alert status change (resolved, dismissed, snoozed,
+	// reopened) to the ticket previously created for the alert
+	SyncStatus(ctx context.Context, ticketID string, alert *models.CustomPrismaAlert) error
+}
+
+// Notifier announces an alert and links to its tickets, e.g. on a Teams channel
+type Notifier interface {
+	// Name identifies the notifier in responses and logs
+	Name() string
+
+	// Enabled reports whether the notifier has a destination for the alert
+	Enabled(alert *models.CustomPrismaAlert, webhookType string) bool
+
+	// Notify sends the notification for the alert
+	Notify(ctx context.Context, alert *models.CustomPrismaAlert, links Links, webhookType string) error
+}
+
+// Links are the URLs a notification can point to
+type Links struct {
+	PrismaURL string
+
+	// Tickets holds the URL of each ticket created for the alert, by sink name
+	Tickets map[string]string
+}
@@ -0,0 +1,258 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"prisma-webhook/channels"
+	"prisma-webhook/metrics"
+	"prisma-webhook/models"
+	"prisma-webhook/routing"
+	"prisma-webhook/templates"
+	"prisma-webhook/tracing"
+	"strings"
+	"time"
+)
+
+type SlackClient struct {
+	rules *routing.Engine
+
+	// default webhook per channel
+	channels *channels.Registry
+}
+
+// Block Kit message for a Slack incoming webhook. The blocks sit in an attachment
+// so the message gets the severity color bar.
+type slackMessage struct {
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments"`
+}
+
+type slackAttachment struct {
+	Color  string       `json:"color"`
+	Blocks []slackBlock `json:"blocks"`
+}
+
+type slackBlock struct {
+	Type     string         `json:"type"`
+	Text     *slackText     `json:"text,omitempty"`
+	Fields   []slackText    `json:"fields,omitempty"`
+	Elements []slackElement `json:"elements,omitempty"`
+}
+
+type slackText struct {
+	Type  string `json:"type"`
+	Text  string `json:"text"`
+	Emoji bool   `json:"emoji,omitempty"`
+}
+
+type slackElement struct {
+	Type     string     `json:"type"`
+	Text     *slackText `json:"text,omitempty"`
+	URL      string     `json:"url,omitempty"`
+	Style    string     `json:"style,omitempty"`
+	ActionID string     `json:"action_id,omitempty"`
+}
+
+func NewSlackClient(rules *routing.Engine, channels *channels.Registry) *SlackClient {
+	return &SlackClient{
+		rules:    rules,
+		channels: channels,
+	}
+}
+
+// webhookURL picks the Slack channel for an alert: the matching routing rule's
+// channel if it has one, otherwise the webhook of the delivery's channel
+func (s *SlackClient) webhookURL(alert *models.CustomPrismaAlert, webhookType string) string {
+	if rule := s.rules.Route(alert, webhookType); rule != nil && rule.SlackWebhookURL != "" {
+		return rule.SlackWebhookURL
+	}
+
+	if channel := s.channels.Get(webhookType); channel != nil {
+		return channel.SlackWebhookURL
+	}
+	return ""
+}
+
+// Name implements Notifier
+func (s *SlackClient) Name() string {
+	return "slack"
+}
+
+// Enabled implements Notifier
+func (s *SlackClient) Enabled(alert *models.CustomPrismaAlert, webhookType string) bool {
+	return s.webhookURL(alert, webhookType) != ""
+}
+
+// Notify implements Notifier by posting the alert as a Block Kit message
+func (s *SlackClient) Notify(ctx context.Context, alert *models.CustomPrismaAlert, links Links, webhookType string) error {
+	webhookUrl := s.webhookURL(alert, webhookType)
+	if webhookUrl == "" {
+		return fmt.Errorf("Slack client is not properly configured")
+	}
+
+	data := templates.CardData(alert, links.Tickets["clickup"], links.PrismaURL, webhookType)
+	data["tags"] = formatTags(alert)
+	data["sharepointUrl"] = links.Tickets["sharepoint"]
+
+	return s.send(ctx, webhookUrl, buildSlackMessage(data))
+}
+
+// buildSlackMessage lays out the same alert fields as the Teams card
+func buildSlackMessage(data map[string]interface{}) slackMessage {
+	str := func(key string) string {
+		v, _ := data[key].(string)
+		if v == "" {
+			return "-"
+		}
+		return v
+	}
+
+	severity := str("severityEmoji")
+	if severity == "-" {
+		severity = str("severityUpper")
+	}
+
+	summary := fmt.Sprintf("%s Prisma Cloud alert: %s", severity, str("policyName"))
+
+	field := func(label string, key string) slackText {
+		return slackText{Type: "mrkdwn", Text: fmt.Sprintf("*%s*\n%s", label, slackEscape(str(key)))}
+	}
+
+	blocks := []slackBlock{
+		{
+			Type: "header",
+			Text: &slackText{Type: "plain_text", Text: truncateRunes(summary, 150), Emoji: true},
+		},
+		{
+			Type: "section",
+			Fields: []slackText{
+				{Type: "mrkdwn", Text: "*Severity*\n" + severity},
+				field("Policy", "policyName"),
+				field("Resource", "resourceName"),
+				field("Account", "accountName"),
+				field("Cloud", "cloudType"),
+				field("Region", "region"),
+				field("Alert Time", "alertTime"),
+				field("Alert ID", "alertId"),
+			},
+		},
+	}
+
+	if tags, _ := data["tags"].(string); tags != "" {
+		blocks = append(blocks, slackBlock{
+			Type: "section",
+			Text: &slackText{Type: "mrkdwn", Text: "*Tags*\n" + slackEscape(tags)},
+		})
+	}
+
+	var buttons []slackElement
+	if url, _ := data["clickupUrl"].(string); url != "" {
+		buttons = append(buttons, slackButton("View ClickUp Task", url, "view_clickup_task", "primary"))
+	}
+	if url, _ := data["sharepointUrl"].(string); url != "" {
+		buttons = append(buttons, slackButton("View SharePoint Page", url, "view_sharepoint_page", ""))
+	}
+	if url, _ := data["prismaUrl"].(string); url != "" {
+		buttons = append(buttons, slackButton("View Prisma Alert", url, "view_prisma_alert", ""))
+	}
+	if len(buttons) > 0 {
+		blocks = append(blocks, slackBlock{Type: "actions", Elements: buttons})
+	}
+
+	return slackMessage{
+		Text: summary,
+		Attachments: []slackAttachment{
+			{Color: slackSeverityColor(str("severity")), Blocks: blocks},
+		},
+	}
+}
+
+// formatTags lists the alert tags as "key=value" pairs
+func formatTags(alert *models.CustomPrismaAlert) string {
+	parts := make([]string, 0, len(alert.Tags))
+	for _, tag := range alert.Tags {
+		parts = append(parts, fmt.Sprintf("%v=%v", tag["key"], tag["value"]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func slackButton(text string, url string, actionID string, style string) slackElement {
+	return slackElement{
+		Type:     "button",
+		Text:     &slackText{Type: "plain_text", Text: text},
+		URL:      url,
+		Style:    style,
+		ActionID: actionID,
+	}
+}
+
+// slackEscape escapes the characters Slack treats as control sequences in mrkdwn
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+// truncateRunes shortens s to at most n characters, as Slack rejects over-long header text
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+// slackSeverityColor returns the attachment color bar for the severity level
+func slackSeverityColor(severity string) string {
+	switch strings.ToLower(severity) {
+	case "critical", "high":
+		return "#D92D20" // Red
+	case "medium":
+		return "#F79009" // Orange/Yellow
+	case "low":
+		return "#12B76A" // Green
+	default:
+		return "#98A2B3" // Grey
+	}
+}
+
+// send posts the message to the Slack incoming webhook
+func (s *SlackClient) send(ctx context.Context, webhookUrl string, message slackMessage) error {
+	jsonData, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal Slack message: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", webhookUrl, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to create Slack webhook request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{}
+	req, span := tracing.StartRequest(req, "slack", "send")
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		metrics.ObserveUpstream("slack", "send", start, 0)
+		tracing.EndRequest(span, 0, err)
+		return fmt.Errorf("failed to send Slack webhook: %w", err)
+	}
+	metrics.ObserveUpstream("slack", "send", start, resp.StatusCode)
+	tracing.EndRequest(span, resp.StatusCode, nil)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read Slack response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return &APIError{Message: "Slack webhook failed", StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	return nil
+}
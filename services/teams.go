@@ -2,704 +2,169 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"prisma-webhook/config"
+	"net/url"
+	"prisma-webhook/channels"
+	"prisma-webhook/metrics"
 	"prisma-webhook/models"
-	"strconv"
-	"strings"
+	"prisma-webhook/routing"
+	"prisma-webhook/templates"
+	"prisma-webhook/tracing"
 	"time"
 )
 
 type TeamsClient struct {
-	webhookAlertaURL    string
-	webhookMandatoryURL string
+	rules *routing.Engine
+
+	// default webhook and Adaptive Card template per channel
+	channels *channels.Registry
 }
 
-// Adaptive Card structures for Power Automate
+// Adaptive Card envelope for Power Automate
 type teamsAdaptiveCardMessage struct {
 	Type        string                        `json:"type"`
 	Attachments []teamsAdaptiveCardAttachment `json:"attachments"`
 }
 
 type teamsAdaptiveCardAttachment struct {
-	ContentType string                   `json:"contentType"`
-	ContentURL  interface{}              `json:"contentUrl"`
-	Content     teamsAdaptiveCardContent `json:"content"`
-}
-
-type teamsAdaptiveCardContent struct {
-	Schema  string                     `json:"$schema"`
-	Type    string                     `json:"type"`
-	Version string                     `json:"version"`
-	Body    []teamsAdaptiveCardElement `json:"body"`
-	Actions []teamsAdaptiveCardAction  `json:"actions,omitempty"`
-}
-
-type teamsAdaptiveCardElement struct {
-	Type      string                    `json:"type"`
-	Text      string                    `json:"text,omitempty"`
-	Size      string                    `json:"size,omitempty"`
-	Weight    string                    `json:"weight,omitempty"`
-	Color     string                    `json:"color,omitempty"`
-	Wrap      bool                      `json:"wrap,omitempty"`
-	Separator bool                      `json:"separator,omitempty"`
-	Spacing   string                    `json:"spacing,omitempty"`
-	Columns   []teamsAdaptiveCardColumn `json:"columns,omitempty"`
-}
-
-type teamsAdaptiveCardColumn struct {
-	Type  string                     `json:"type"`
-	Width string                     `json:"width,omitempty"`
-	Items []teamsAdaptiveCardElement `json:"items"`
+	ContentType string                 `json:"contentType"`
+	ContentURL  interface{}            `json:"contentUrl"`
+	Content     map[string]interface{} `json:"content"`
 }
 
-type teamsAdaptiveCardAction struct {
-	Type  string `json:"type"`
-	Title string `json:"title"`
-	URL   string `json:"url"`
-}
-
-func NewTeamsClient(cfg *config.Config) *TeamsClient {
+func NewTeamsClient(rules *routing.Engine, channels *channels.Registry) *TeamsClient {
 	return &TeamsClient{
-		webhookAlertaURL:    cfg.TeamsAlertaWebhookURL,
-		webhookMandatoryURL: cfg.TeamsMandatoryWebhookURL,
+		rules:    rules,
+		channels: channels,
 	}
 }
 
-// IsEnabled returns true if the TeamsClient is properly configured
-func (t *TeamsClient) IsEnabled() bool {
-	return t.webhookAlertaURL != "" && t.webhookMandatoryURL != ""
+// IsEnabledFor returns true if a Teams channel is configured for the alert,
+// either by a routing rule or by the channel default
+func (t *TeamsClient) IsEnabledFor(alert *models.CustomPrismaAlert, webhookType string) bool {
+	return t.webhookURL(alert, webhookType) != ""
 }
 
-// SendTeamsNotification sends an Adaptive Card notification to Microsoft Teams via webhook (Power Automate)
-func (t *TeamsClient) SendTeamsNotification(alert *models.PrismaAlert, clickupURL string, prismaURL string) error {
-	if !t.IsEnabled() {
-		return fmt.Errorf("Teams client is not properly configured")
-	}
-
-	// Extract alert details with fallbacks
-	severity := alert.Policy.Severity
-	if severity == "" {
-		severity = alert.Severity
-	}
-
-	policyName := alert.Policy.Name
-	if policyName == "" {
-		policyName = alert.PolicyName
+// webhookURL picks the Teams channel for an alert: the matching routing rule's
+// channel if it has one, otherwise the webhook of the delivery's channel
+func (t *TeamsClient) webhookURL(alert *models.CustomPrismaAlert, webhookType string) string {
+	if rule := t.rules.Route(alert, webhookType); rule != nil && rule.TeamsWebhookURL != "" {
+		return rule.TeamsWebhookURL
 	}
 
-	resourceName := alert.Resource.ResourceName
-	if resourceName == "" {
-		resourceName = alert.ResourceName
+	if channel := t.channels.Get(webhookType); channel != nil {
+		return channel.TeamsWebhookURL
 	}
+	return ""
+}
 
-	accountName := alert.Account.Name
-	if accountName == "" {
-		accountName = alert.AccountName
-	}
+// Name implements Notifier
+func (t *TeamsClient) Name() string {
+	return "teams"
+}
 
-	region := alert.Region
-	if region == "" {
-		region = alert.ResourceRegion
-	}
+// Enabled implements Notifier
+func (t *TeamsClient) Enabled(alert *models.CustomPrismaAlert, webhookType string) bool {
+	return t.IsEnabledFor(alert, webhookType)
+}
 
-	cloudType := alert.Account.CloudType
-	if cloudType == "" {
-		cloudType = alert.CloudType
+// Notify implements Notifier by sending the alert's Adaptive Card
+func (t *TeamsClient) Notify(ctx context.Context, alert *models.CustomPrismaAlert, links Links, webhookType string) error {
+	webhookUrl := t.webhookURL(alert, webhookType)
+	if webhookUrl == "" {
+		return fmt.Errorf("Teams client is not properly configured")
 	}
 
-	// Determine severity color
-	severityColor := t.getSeverityColorName(severity)
+	data := templates.CardData(alert, links.Tickets["clickup"], links.PrismaURL, webhookType)
+	data["sharepointUrl"] = links.Tickets["sharepoint"]
 
-	// parse alertTs
-	i, err := strconv.ParseInt(alert.AlertTs, 10, 64)
-	if err != nil {
-		return fmt.Errorf("Failed to convert alert timestamp to int: %v", err)
-	}
-	alertTime := time.UnixMilli(i)
+	return t.send(ctx, webhookUrl, webhookType, data)
+}
 
-	// Build Adaptive Card body
-	body := []teamsAdaptiveCardElement{
-		{
-			Type:   "TextBlock",
-			Text:   "🔔 Prisma Cloud Security Alert",
-			Size:   "Large",
-			Weight: "Bolder",
-			Wrap:   true,
-		},
-		{
-			Type:      "TextBlock",
-			Text:      fmt.Sprintf("**Severity:** %s", strings.ToUpper(severity)),
-			Color:     severityColor,
-			Size:      "Medium",
-			Weight:    "Bolder",
-			Wrap:      true,
-			Separator: true,
-		},
-		{
-			Type:    "TextBlock",
-			Text:    "**Policy Violation Details**",
-			Weight:  "Bolder",
-			Spacing: "Medium",
-			Wrap:    true,
-		},
-		// Policy Name
-		{
-			Type: "ColumnSet",
-			Columns: []teamsAdaptiveCardColumn{
-				{
-					Type:  "Column",
-					Width: "auto",
-					Items: []teamsAdaptiveCardElement{
-						{
-							Type:   "TextBlock",
-							Text:   "**Policy:**",
-							Weight: "Bolder",
-							Wrap:   true,
-						},
-					},
-				},
-				{
-					Type:  "Column",
-					Width: "stretch",
-					Items: []teamsAdaptiveCardElement{
-						{
-							Type: "TextBlock",
-							Text: policyName,
-							Wrap: true,
-						},
-					},
-				},
-			},
-		},
-		// Resource Name
-		{
-			Type: "ColumnSet",
-			Columns: []teamsAdaptiveCardColumn{
-				{
-					Type:  "Column",
-					Width: "auto",
-					Items: []teamsAdaptiveCardElement{
-						{
-							Type:   "TextBlock",
-							Text:   "**Resource:**",
-							Weight: "Bolder",
-							Wrap:   true,
-						},
-					},
-				},
-				{
-					Type:  "Column",
-					Width: "stretch",
-					Items: []teamsAdaptiveCardElement{
-						{
-							Type: "TextBlock",
-							Text: resourceName,
-							Wrap: true,
-						},
-					},
-				},
-			},
-		},
-		// Account
-		{
-			Type: "ColumnSet",
-			Columns: []teamsAdaptiveCardColumn{
-				{
-					Type:  "Column",
-					Width: "auto",
-					Items: []teamsAdaptiveCardElement{
-						{
-							Type:   "TextBlock",
-							Text:   "**Account:**",
-							Weight: "Bolder",
-							Wrap:   true,
-						},
-					},
-				},
-				{
-					Type:  "Column",
-					Width: "stretch",
-					Items: []teamsAdaptiveCardElement{
-						{
-							Type: "TextBlock",
-							Text: accountName,
-							Wrap: true,
-						},
-					},
-				},
-			},
-		},
-		// Cloud Type
-		{
-			Type: "ColumnSet",
-			Columns: []teamsAdaptiveCardColumn{
-				{
-					Type:  "Column",
-					Width: "auto",
-					Items: []teamsAdaptiveCardElement{
-						{
-							Type:   "TextBlock",
-							Text:   "**Cloud:**",
-							Weight: "Bolder",
-							Wrap:   true,
-						},
-					},
-				},
-				{
-					Type:  "Column",
-					Width: "stretch",
-					Items: []teamsAdaptiveCardElement{
-						{
-							Type: "TextBlock",
-							Text: cloudType,
-							Wrap: true,
-						},
-					},
-				},
-			},
-		},
-		// Region
-		{
-			Type: "ColumnSet",
-			Columns: []teamsAdaptiveCardColumn{
-				{
-					Type:  "Column",
-					Width: "auto",
-					Items: []teamsAdaptiveCardElement{
-						{
-							Type:   "TextBlock",
-							Text:   "**Region:**",
-							Weight: "Bolder",
-							Wrap:   true,
-						},
-					},
-				},
-				{
-					Type:  "Column",
-					Width: "stretch",
-					Items: []teamsAdaptiveCardElement{
-						{
-							Type: "TextBlock",
-							Text: region,
-							Wrap: true,
-						},
-					},
-				},
-			},
-		},
-		// Alert Time
-		{
-			Type: "ColumnSet",
-			Columns: []teamsAdaptiveCardColumn{
-				{
-					Type:  "Column",
-					Width: "auto",
-					Items: []teamsAdaptiveCardElement{
-						{
-							Type:   "TextBlock",
-							Text:   "**Alert Time:**",
-							Weight: "Bolder",
-							Wrap:   true,
-						},
-					},
-				},
-				{
-					Type:  "Column",
-					Width: "stretch",
-					Items: []teamsAdaptiveCardElement{
-						{
-							Type: "TextBlock",
-							Text: alertTime.Format("2006-01-02 15:04:05 +0700"),
-							Wrap: true,
-						},
-					},
-				},
-			},
-		},
+// CheckHealth verifies that every Teams channel, the channel defaults and the routing
+// rules' channels, answers HTTP requests. No card is posted, so a response below 500
+// to the bodiless GET counts as reachable.
+func (t *TeamsClient) CheckHealth(ctx context.Context) error {
+	var channels [][2]string
+	for _, channel := range t.channels.All() {
+		channels = append(channels, [2]string{channel.Name, channel.TeamsWebhookURL})
 	}
-
-	// Build actions
-	var actions []teamsAdaptiveCardAction
-	if clickupURL != "" {
-		actions = append(actions, teamsAdaptiveCardAction{
-			Type:  "Action.OpenUrl",
-			Title: "View ClickUp Task",
-			URL:   clickupURL,
-		})
+	for _, rule := range t.rules.Rules() {
+		channels = append(channels, [2]string{"rule " + rule.Name, rule.TeamsWebhookURL})
 	}
 
-	if prismaURL != "" {
-		actions = append(actions, teamsAdaptiveCardAction{
-			Type:  "Action.OpenUrl",
-			Title: "View Prisma Detail Alert",
-			URL:   prismaURL,
-		})
-	}
+	var errs []error
+	seen := make(map[string]bool)
+	for _, channel := range channels {
+		name, webhookUrl := channel[0], channel[1]
+		if webhookUrl == "" || seen[webhookUrl] {
+			continue
+		}
+		seen[webhookUrl] = true
 
-	// Build the Adaptive Card
-	adaptiveCard := teamsAdaptiveCardMessage{
-		Type: "message",
-		Attachments: []teamsAdaptiveCardAttachment{
-			{
-				ContentType: "application/vnd.microsoft.card.adaptive",
-				ContentURL:  nil,
-				Content: teamsAdaptiveCardContent{
-					Schema:  "http://adaptivecards.io/schemas/adaptive-card.json",
-					Type:    "AdaptiveCard",
-					Version: "1.4",
-					Body:    body,
-					Actions: actions,
-				},
-			},
-		},
+		if err := t.ping(ctx, webhookUrl); err != nil {
+			errs = append(errs, fmt.Errorf("Teams %s channel unreachable: %w", name, err))
+		}
 	}
+	return errors.Join(errs...)
+}
 
-	// Marshal to JSON
-	jsonData, err := json.Marshal(adaptiveCard)
+// ping sends a GET to the webhook URL. Errors leave the URL out, as its query holds
+// the webhook's signature.
+func (t *TeamsClient) ping(ctx context.Context, webhookUrl string) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", webhookUrl, nil)
 	if err != nil {
-		return fmt.Errorf("failed to marshal Teams adaptive card: %w", err)
+		return errors.New("invalid webhook URL")
 	}
 
-	// Send the webhook
-	req, err := http.NewRequest("POST", t.webhookAlertaURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("failed to create Teams webhook request: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-
 	client := &http.Client{}
+	req, span := tracing.StartRequest(req, "teams", "check")
+	start := time.Now()
 	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to send Teams webhook: %w", err)
-	}
-	defer resp.Body.Close()
-
-	body2, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to read Teams response: %w", err)
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		metrics.ObserveUpstream("teams", "check", start, 0)
+		tracing.EndRequest(span, 0, err)
+		return err
 	}
+	metrics.ObserveUpstream("teams", "check", start, resp.StatusCode)
+	tracing.EndRequest(span, resp.StatusCode, nil)
+	resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
-		return fmt.Errorf("Teams webhook failed (status %d): %s", resp.StatusCode, string(body2))
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("status %d", resp.StatusCode)
 	}
-
 	return nil
 }
 
-func (t *TeamsClient) SendTeamsNotificationV2(alert *models.CustomPrismaAlert, clickupURL string, prismaURL string, webhookType string) error {
-	if !t.IsEnabled() {
-		return fmt.Errorf("Teams client is not properly configured")
-	}
-
-	// Extract alert details with fallbacks
-	severity := ""
-	if alert.Severity != "" {
-		severity = alert.Severity
+// send renders the channel's card template with data and posts it to the Teams webhook
+func (t *TeamsClient) send(ctx context.Context, webhookUrl string, webhookType string, data map[string]interface{}) error {
+	channel := t.channels.Get(webhookType)
+	if channel == nil {
+		return &PermanentError{Err: fmt.Errorf("unknown channel: %s", webhookType)}
 	}
 
-	policyName := ""
-	if alert.PolicyName != "" {
-		policyName = alert.PolicyName
-	}
-
-	resourceName := ""
-	if alert.ResourceName != "" {
-		resourceName = alert.ResourceName
-	}
-
-	accountName := ""
-	if alert.AccountName != "" {
-		accountName = alert.AccountName
-	}
-
-	region := ""
-	if alert.ResourceRegion != "" {
-		region = alert.ResourceRegion
-	}
-
-	cloudType := ""
-	if alert.CloudType != "" {
-		cloudType = alert.CloudType
-	}
-
-	tags := ""
-	if alert.Tags != nil {
-		tags = fmt.Sprintf("%v", alert.Tags)
-	}
-
-	// Determine severity color
-	severityColor := t.getSeverityColorName(severity)
-
-	// parse alertTs
-	alertTime := time.UnixMilli(alert.AlertTs)
-
-	// Build Adaptive Card body
-	body := []teamsAdaptiveCardElement{
-		{
-			Type:   "TextBlock",
-			Text:   "🔔 Prisma Cloud Security Alert",
-			Size:   "Large",
-			Weight: "Bolder",
-			Wrap:   true,
-		},
-		{
-			Type:      "TextBlock",
-			Text:      fmt.Sprintf("**Severity:** %s", strings.ToUpper(severity)),
-			Color:     severityColor,
-			Size:      "Medium",
-			Weight:    "Bolder",
-			Wrap:      true,
-			Separator: true,
-		},
-		{
-			Type:    "TextBlock",
-			Text:    "**Policy Violation Details**",
-			Weight:  "Bolder",
-			Spacing: "Medium",
-			Wrap:    true,
-		},
-		// Policy Name
-		{
-			Type: "ColumnSet",
-			Columns: []teamsAdaptiveCardColumn{
-				{
-					Type:  "Column",
-					Width: "auto",
-					Items: []teamsAdaptiveCardElement{
-						{
-							Type:   "TextBlock",
-							Text:   "**Policy:**",
-							Weight: "Bolder",
-							Wrap:   true,
-						},
-					},
-				},
-				{
-					Type:  "Column",
-					Width: "stretch",
-					Items: []teamsAdaptiveCardElement{
-						{
-							Type: "TextBlock",
-							Text: policyName,
-							Wrap: true,
-						},
-					},
-				},
-			},
-		},
-		// Resource Name
-		{
-			Type: "ColumnSet",
-			Columns: []teamsAdaptiveCardColumn{
-				{
-					Type:  "Column",
-					Width: "auto",
-					Items: []teamsAdaptiveCardElement{
-						{
-							Type:   "TextBlock",
-							Text:   "**Resource:**",
-							Weight: "Bolder",
-							Wrap:   true,
-						},
-					},
-				},
-				{
-					Type:  "Column",
-					Width: "stretch",
-					Items: []teamsAdaptiveCardElement{
-						{
-							Type: "TextBlock",
-							Text: resourceName,
-							Wrap: true,
-						},
-					},
-				},
-			},
-		},
-		// Account
-		{
-			Type: "ColumnSet",
-			Columns: []teamsAdaptiveCardColumn{
-				{
-					Type:  "Column",
-					Width: "auto",
-					Items: []teamsAdaptiveCardElement{
-						{
-							Type:   "TextBlock",
-							Text:   "**Account:**",
-							Weight: "Bolder",
-							Wrap:   true,
-						},
-					},
-				},
-				{
-					Type:  "Column",
-					Width: "stretch",
-					Items: []teamsAdaptiveCardElement{
-						{
-							Type: "TextBlock",
-							Text: accountName,
-							Wrap: true,
-						},
-					},
-				},
-			},
-		},
-		// Cloud Type
-		{
-			Type: "ColumnSet",
-			Columns: []teamsAdaptiveCardColumn{
-				{
-					Type:  "Column",
-					Width: "auto",
-					Items: []teamsAdaptiveCardElement{
-						{
-							Type:   "TextBlock",
-							Text:   "**Cloud:**",
-							Weight: "Bolder",
-							Wrap:   true,
-						},
-					},
-				},
-				{
-					Type:  "Column",
-					Width: "stretch",
-					Items: []teamsAdaptiveCardElement{
-						{
-							Type: "TextBlock",
-							Text: cloudType,
-							Wrap: true,
-						},
-					},
-				},
-			},
-		},
-		// Region
-		{
-			Type: "ColumnSet",
-			Columns: []teamsAdaptiveCardColumn{
-				{
-					Type:  "Column",
-					Width: "auto",
-					Items: []teamsAdaptiveCardElement{
-						{
-							Type:   "TextBlock",
-							Text:   "**Region:**",
-							Weight: "Bolder",
-							Wrap:   true,
-						},
-					},
-				},
-				{
-					Type:  "Column",
-					Width: "stretch",
-					Items: []teamsAdaptiveCardElement{
-						{
-							Type: "TextBlock",
-							Text: region,
-							Wrap: true,
-						},
-					},
-				},
-			},
-		},
-		// Alert Time
-		{
-			Type: "ColumnSet",
-			Columns: []teamsAdaptiveCardColumn{
-				{
-					Type:  "Column",
-					Width: "auto",
-					Items: []teamsAdaptiveCardElement{
-						{
-							Type:   "TextBlock",
-							Text:   "**Alert Time:**",
-							Weight: "Bolder",
-							Wrap:   true,
-						},
-					},
-				},
-				{
-					Type:  "Column",
-					Width: "stretch",
-					Items: []teamsAdaptiveCardElement{
-						{
-							Type: "TextBlock",
-							Text: alertTime.Format("2006-01-02 15:04:05 +0700"),
-							Wrap: true,
-						},
-					},
-				},
-			},
-		},
-		// Tags
-		{
-			Type: "ColumnSet",
-			Columns: []teamsAdaptiveCardColumn{
-				{
-					Type:  "Column",
-					Width: "auto",
-					Items: []teamsAdaptiveCardElement{
-						{
-							Type:   "TextBlock",
-							Text:   "**Tags:**",
-							Weight: "Bolder",
-							Wrap:   true,
-						},
-					},
-				},
-				{
-					Type:  "Column",
-					Width: "stretch",
-					Items: []teamsAdaptiveCardElement{
-						{
-							Type: "TextBlock",
-							Text: tags,
-							Wrap: true,
-						},
-					},
-				},
-			},
-		},
-	}
-
-	// Build actions
-	var actions []teamsAdaptiveCardAction
-	if clickupURL != "" {
-		actions = append(actions, teamsAdaptiveCardAction{
-			Type:  "Action.OpenUrl",
-			Title: "View ClickUp Task",
-			URL:   clickupURL,
-		})
-	}
-
-	if prismaURL != "" {
-		actions = append(actions, teamsAdaptiveCardAction{
-			Type:  "Action.OpenUrl",
-			Title: "View Prisma Detail Alert",
-			URL:   prismaURL,
-		})
+	content, err := channel.Card.Render(data)
+	if err != nil {
+		return &PermanentError{Err: fmt.Errorf("failed to render Teams adaptive card: %w", err)}
 	}
 
-	// Build the Adaptive Card
+	// Build the Adaptive Card message
 	adaptiveCard := teamsAdaptiveCardMessage{
 		Type: "message",
 		Attachments: []teamsAdaptiveCardAttachment{
 			{
 				ContentType: "application/vnd.microsoft.card.adaptive",
 				ContentURL:  nil,
-				Content: teamsAdaptiveCardContent{
-					Schema:  "http://adaptivecards.io/schemas/adaptive-card.json",
-					Type:    "AdaptiveCard",
-					Version: "1.4",
-					Body:    body,
-					Actions: actions,
-				},
+				Content:     content,
 			},
 		},
 	}
@@ -711,12 +176,7 @@ func (t *TeamsClient) SendTeamsNotificationV2(alert *models.CustomPrismaAlert, c
 	}
 
 	// Send the webhook
-	webhookUrl := t.webhookAlertaURL
-	if webhookType == "mandatory" {
-		webhookUrl = t.webhookMandatoryURL
-	}
-
-	req, err := http.NewRequest("POST", webhookUrl, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", webhookUrl, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return fmt.Errorf("failed to create Teams webhook request: %w", err)
 	}
@@ -724,36 +184,26 @@ func (t *TeamsClient) SendTeamsNotificationV2(alert *models.CustomPrismaAlert, c
 	req.Header.Set("Content-Type", "application/json")
 
 	client := &http.Client{}
+	req, span := tracing.StartRequest(req, "teams", "send")
+	start := time.Now()
 	resp, err := client.Do(req)
 	if err != nil {
+		metrics.ObserveUpstream("teams", "send", start, 0)
+		tracing.EndRequest(span, 0, err)
 		return fmt.Errorf("failed to send Teams webhook: %w", err)
 	}
+	metrics.ObserveUpstream("teams", "send", start, resp.StatusCode)
+	tracing.EndRequest(span, resp.StatusCode, nil)
 	defer resp.Body.Close()
 
-	body2, err := io.ReadAll(resp.Body)
+	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return fmt.Errorf("failed to read Teams response: %w", err)
 	}
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
-		return fmt.Errorf("Teams webhook failed (status %d): %s", resp.StatusCode, string(body2))
+		return &APIError{Message: "Teams webhook failed", StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
 	return nil
 }
-
-// getSeverityColorName returns an Adaptive Card color name for the severity level
-func (t *TeamsClient) getSeverityColorName(severity string) string {
-	switch strings.ToLower(severity) {
-	case "critical":
-		return "Attention" // Red
-	case "high":
-		return "Attention" // Red
-	case "medium":
-		return "Warning" // Orange/Yellow
-	case "low":
-		return "Good" // Green
-	default:
-		return "Default" // Default text color
-	}
-}
@@ -0,0 +1,147 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Dead letter statuses
+const (
+	DeadLetterPending  = "pending"
+	DeadLetterReplayed = "replayed"
+)
+
+// DeadLetter is an alert delivery that failed after all retries
+type DeadLetter struct {
+	ID          string          `json:"id"`
+	JobID       string          `json:"jobId"`
+	AlertID     string          `json:"alertId"`
+	XType       string          `json:"xType"`
+	Sink        string          `json:"sink,omitempty"`
+	Payload     json.RawMessage `json:"payload"`
+	Stage       string          `json:"stage"`
+	Error       string          `json:"error"`
+	Attempts    int             `json:"attempts"`
+	Status      string          `json:"status"`
+	ReplayJobID string          `json:"replayJobId,omitempty"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	UpdatedAt   time.Time       `json:"updatedAt"`
+}
+
+// AddDeadLetter stores a failed delivery as pending
+func (s *Store) AddDeadLetter(dl *DeadLetter) error {
+	if dl.ID == "" {
+		dl.ID = uuid.NewString()
+	}
+	if dl.Status == "" {
+		dl.Status = DeadLetterPending
+	}
+	return s.SaveDeadLetter(dl)
+}
+
+// SaveDeadLetter inserts or replaces dl
+func (s *Store) SaveDeadLetter(dl *DeadLetter) error {
+	dl.UpdatedAt = time.Now().UTC()
+	if dl.CreatedAt.IsZero() {
+		dl.CreatedAt = dl.UpdatedAt
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx.Bucket(dlqBucket), dl.ID, dl)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save dead letter %s: %w", dl.ID, err)
+	}
+	return nil
+}
+
+// GetDeadLetter returns the dead letter with the given ID, or nil if it does not exist
+func (s *Store) GetDeadLetter(id string) (*DeadLetter, error) {
+	var dl *DeadLetter
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(dlqBucket).Get([]byte(id))
+		if data == nil {
+			return nil
+		}
+		dl = &DeadLetter{}
+		return json.Unmarshal(data, dl)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dead letter %s: %w", id, err)
+	}
+	return dl, nil
+}
+
+// ListDeadLetters returns dead letters with the given status (all if empty), newest first
+func (s *Store) ListDeadLetters(status string) ([]*DeadLetter, error) {
+	dls := []*DeadLetter{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(dlqBucket).ForEach(func(_, data []byte) error {
+			dl := &DeadLetter{}
+			if err := json.Unmarshal(data, dl); err != nil {
+				return err
+			}
+			if status == "" || dl.Status == status {
+				dls = append(dls, dl)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list dead letters: %w", err)
+	}
+
+	sort.Slice(dls, func(i, j int) bool {
+		return dls[i].CreatedAt.After(dls[j].CreatedAt)
+	})
+	return dls, nil
+}
+
+// DeleteDeadLetter removes the dead letter with the given ID
+func (s *Store) DeleteDeadLetter(id string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(dlqBucket).Delete([]byte(id))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete dead letter %s: %w", id, err)
+	}
+	return nil
+}
+
+// PruneDeadLetters deletes replayed dead letters last updated before the given time.
+// Pending ones are kept until they are replayed or deleted.
+func (s *Store) PruneDeadLetters(before time.Time) (int, error) {
+	var stale [][]byte
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(dlqBucket)
+		err := b.ForEach(func(k, data []byte) error {
+			dl := &DeadLetter{}
+			if err := json.Unmarshal(data, dl); err != nil {
+				return err
+			}
+			if dl.Status == DeadLetterReplayed && dl.UpdatedAt.Before(before) {
+				stale = append(stale, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range stale {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune dead letters: %w", err)
+	}
+	return len(stale), nil
+}
@@ -0,0 +1,239 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Delivery statuses
+const (
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// AlertHistory is what the service received for one Prisma alert, kept for auditing
+// independently of the dedup record
+type AlertHistory struct {
+	AlertID     string `json:"alertId"`
+	XType       string `json:"xType"`
+	Severity    string `json:"severity"`
+	CloudType   string `json:"cloudType"`
+	AccountID   string `json:"accountId"`
+	AccountName string `json:"accountName"`
+	PolicyID    string `json:"policyId"`
+	PolicyName  string `json:"policyName"`
+	AlertStatus string `json:"alertStatus"`
+
+	// Filtered is set when the last delivery was skipped by the channel's filter
+	Filtered bool `json:"filtered"`
+
+	// Received counts the webhook deliveries that carried the alert
+	Received        int       `json:"received"`
+	LastJobID       string    `json:"lastJobId"`
+	FirstReceivedAt time.Time `json:"firstReceivedAt"`
+	LastReceivedAt  time.Time `json:"lastReceivedAt"`
+
+	// Payload is the alert as last received
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Delivery is one attempt to hand an alert to a ticket sink or notifier
+type Delivery struct {
+	AlertID    string    `json:"alertId"`
+	JobID      string    `json:"jobId"`
+	XType      string    `json:"xType"`
+	Sink       string    `json:"sink"`
+	Stage      string    `json:"stage"`
+	Attempt    int       `json:"attempt"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"durationMs"`
+	At         time.Time `json:"at"`
+}
+
+// RecordAlert adds a received delivery of h.AlertID to its history, keeping the
+// first receipt time and counting the deliveries
+func (s *Store) RecordAlert(h *AlertHistory) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(historyBucket)
+		now := time.Now().UTC()
+
+		h.Received = 1
+		h.FirstReceivedAt = now
+		if data := b.Get([]byte(h.AlertID)); data != nil {
+			prev := &AlertHistory{}
+			if err := json.Unmarshal(data, prev); err != nil {
+				return err
+			}
+			h.Received = prev.Received + 1
+			h.FirstReceivedAt = prev.FirstReceivedAt
+		}
+		h.LastReceivedAt = now
+
+		return putJSON(b, h.AlertID, h)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record alert %s: %w", h.AlertID, err)
+	}
+	return nil
+}
+
+// GetAlertHistory returns the history of alertID, or nil if it was never received
+func (s *Store) GetAlertHistory(alertID string) (*AlertHistory, error) {
+	var h *AlertHistory
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(historyBucket).Get([]byte(alertID))
+		if data == nil {
+			return nil
+		}
+		h = &AlertHistory{}
+		return json.Unmarshal(data, h)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read alert history %s: %w", alertID, err)
+	}
+	return h, nil
+}
+
+// ListAlertHistory returns the histories keep accepts, most recently received first.
+// Payloads are left out.
+func (s *Store) ListAlertHistory(keep func(*AlertHistory) bool) ([]*AlertHistory, error) {
+	list := []*AlertHistory{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(historyBucket).ForEach(func(_, data []byte) error {
+			h := &AlertHistory{}
+			if err := json.Unmarshal(data, h); err != nil {
+				return err
+			}
+			if keep(h) {
+				h.Payload = nil
+				list = append(list, h)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list alert history: %w", err)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].LastReceivedAt.After(list[j].LastReceivedAt)
+	})
+	return list, nil
+}
+
+// AddDelivery appends a delivery attempt to the history of d.AlertID
+func (s *Store) AddDelivery(d *Delivery) error {
+	if d.At.IsZero() {
+		d.At = time.Now().UTC()
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(deliveriesBucket)
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		return putJSON(b, deliveryKey(d.AlertID, seq), d)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record delivery of alert %s: %w", d.AlertID, err)
+	}
+	return nil
+}
+
+// ListDeliveries returns the delivery attempts of alertID, oldest first
+func (s *Store) ListDeliveries(alertID string) ([]*Delivery, error) {
+	list := []*Delivery{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		prefix := []byte(deliveryKey(alertID, 0)[:len(alertID)+1])
+		c := tx.Bucket(deliveriesBucket).Cursor()
+		for k, data := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, data = c.Next() {
+			d := &Delivery{}
+			if err := json.Unmarshal(data, d); err != nil {
+				return err
+			}
+			list = append(list, d)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deliveries of alert %s: %w", alertID, err)
+	}
+	return list, nil
+}
+
+// ForEachDelivery calls fn with every recorded delivery attempt
+func (s *Store) ForEachDelivery(fn func(*Delivery)) error {
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(deliveriesBucket).ForEach(func(_, data []byte) error {
+			d := &Delivery{}
+			if err := json.Unmarshal(data, d); err != nil {
+				return err
+			}
+			fn(d)
+			return nil
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read deliveries: %w", err)
+	}
+	return nil
+}
+
+// PruneHistory deletes the histories of alerts last received before the given time,
+// along with their delivery attempts
+func (s *Store) PruneHistory(before time.Time) (int, error) {
+	pruned := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		history := tx.Bucket(historyBucket)
+		var stale []string
+		err := history.ForEach(func(k, data []byte) error {
+			h := &AlertHistory{}
+			if err := json.Unmarshal(data, h); err != nil {
+				return err
+			}
+			if h.LastReceivedAt.Before(before) {
+				stale = append(stale, string(k))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		deliveries := tx.Bucket(deliveriesBucket)
+		for _, alertID := range stale {
+			if err := history.Delete([]byte(alertID)); err != nil {
+				return err
+			}
+
+			prefix := []byte(alertID + deliveryKeySep)
+			c := deliveries.Cursor()
+			for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Seek(prefix) {
+				if err := c.Delete(); err != nil {
+					return err
+				}
+			}
+		}
+		pruned = len(stale)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune alert history: %w", err)
+	}
+	return pruned, nil
+}
+
+// deliveryKeySep separates the alert ID from the sequence number in delivery keys,
+// so the attempts of one alert are stored together in order
+const deliveryKeySep = "\x00"
+
+func deliveryKey(alertID string, seq uint64) string {
+	return fmt.Sprintf("%s%s%020d", alertID, deliveryKeySep, seq)
+}
@@ -0,0 +1,137 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Job statuses
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+)
+
+// Job is a persisted webhook delivery waiting for, or finished with, processing
+type Job struct {
+	ID        string          `json:"id"`
+	XType     string          `json:"xType"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	Status    string          `json:"status"`
+	Attempts  int             `json:"attempts"`
+	Result    json.RawMessage `json:"result,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	CreatedAt time.Time       `json:"createdAt"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+
+	// RequestID and TraceContext tie the job to the request that queued it
+	RequestID    string            `json:"requestId,omitempty"`
+	TraceContext map[string]string `json:"traceContext,omitempty"`
+}
+
+// SaveJob inserts or replaces job
+func (s *Store) SaveJob(job *Job) error {
+	job.UpdatedAt = time.Now().UTC()
+	if job.CreatedAt.IsZero() {
+		job.CreatedAt = job.UpdatedAt
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx.Bucket(jobsBucket), job.ID, job)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save job %s: %w", job.ID, err)
+	}
+	return nil
+}
+
+// GetJob returns the job with the given ID, or nil if it does not exist
+func (s *Store) GetJob(id string) (*Job, error) {
+	var job *Job
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(jobsBucket).Get([]byte(id))
+		if data == nil {
+			return nil
+		}
+		job = &Job{}
+		return json.Unmarshal(data, job)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read job %s: %w", id, err)
+	}
+	return job, nil
+}
+
+// DeleteJob removes the job with the given ID
+func (s *Store) DeleteJob(id string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).Delete([]byte(id))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete job %s: %w", id, err)
+	}
+	return nil
+}
+
+// PendingJobs returns every job that is queued or was running when the service stopped,
+// oldest first
+func (s *Store) PendingJobs() ([]*Job, error) {
+	var jobs []*Job
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).ForEach(func(_, data []byte) error {
+			job := &Job{}
+			if err := json.Unmarshal(data, job); err != nil {
+				return err
+			}
+			if job.Status == JobQueued || job.Status == JobRunning {
+				jobs = append(jobs, job)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pending jobs: %w", err)
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.Before(jobs[j].CreatedAt)
+	})
+	return jobs, nil
+}
+
+// PruneJobs deletes finished jobs last updated before the given time
+func (s *Store) PruneJobs(before time.Time) (int, error) {
+	var stale [][]byte
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(jobsBucket)
+		err := b.ForEach(func(k, data []byte) error {
+			job := &Job{}
+			if err := json.Unmarshal(data, job); err != nil {
+				return err
+			}
+			if (job.Status == JobCompleted || job.Status == JobFailed) && job.UpdatedAt.Before(before) {
+				stale = append(stale, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range stale {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune jobs: %w", err)
+	}
+	return len(stale), nil
+}
@@ -0,0 +1,378 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const dbFileName = "webhook.db"
+
+// pendingClaimTimeout is how long the claim of a job still creating an alert's
+// tickets or sending its notifications blocks other jobs delivering the same alert
+// before it is considered abandoned. The claiming job refreshes it between
+// attempts, so it only runs out when the job is gone.
+const pendingClaimTimeout = 5 * time.Minute
+
+// Notification states of an alert record, by notifier
+const (
+	NotificationPending = "pending"
+	NotificationSent    = "sent"
+	NotificationFailed  = "failed" // moved to the dead-letter queue
+)
+
+var (
+	alertsBucket     = []byte("alerts")
+	jobsBucket       = []byte("jobs")
+	dlqBucket        = []byte("dead_letters")
+	healthBucket     = []byte("health")
+	historyBucket    = []byte("alert_history")
+	deliveriesBucket = []byte("deliveries")
+)
+
+// Store is the embedded bbolt database used to persist webhook state
+type Store struct {
+	db *bolt.DB
+}
+
+// AlertRecord maps a Prisma Cloud alert to the tickets created for it, keyed by sink name
+type AlertRecord struct {
+	AlertID   string                `json:"alertId"`
+	XType     string                `json:"xType"`
+	Status    string                `json:"status"`
+	Tickets   map[string]*TicketRef `json:"tickets,omitempty"`
+	CreatedAt time.Time             `json:"createdAt"`
+	UpdatedAt time.Time             `json:"updatedAt"`
+
+	// JobID is the job holding the claim while the alert is in progress
+	JobID string `json:"jobId,omitempty"`
+
+	// Notifications holds the state of each notifier enabled for the alert when its
+	// first ticket was recorded. Pending ones are sent by a resumed or later job.
+	Notifications map[string]string `json:"notifications,omitempty"`
+
+	// TaskID and TaskURL hold the ClickUp task of records written before tickets
+	// were tracked per sink; they are moved into Tickets when the record is read
+	TaskID  string `json:"taskId,omitempty"`
+	TaskURL string `json:"taskUrl,omitempty"`
+}
+
+// TicketRef is a ticket created by one sink for an alert
+type TicketRef struct {
+	ID     string `json:"id"`
+	URL    string `json:"url,omitempty"`
+	Status string `json:"status"` // alert status last applied to the ticket
+}
+
+// InProgress reports whether a job has yet to finish the alert: it has no tickets
+// yet or notifications still pending
+func (r *AlertRecord) InProgress() bool {
+	if len(r.Tickets) == 0 {
+		return true
+	}
+	for _, state := range r.Notifications {
+		if state == NotificationPending {
+			return true
+		}
+	}
+	return false
+}
+
+// heldByOther reports whether a job other than jobID is working on the alert and
+// refreshed its claim within pendingClaimTimeout
+func (r *AlertRecord) heldByOther(jobID string, now time.Time) bool {
+	return r.InProgress() && r.JobID != "" && r.JobID != jobID && now.Sub(r.UpdatedAt) < pendingClaimTimeout
+}
+
+// Open opens (or creates) the store database inside dataDir
+func Open(dataDir string) (*Store, error) {
+	if err := os.MkdirAll(dataDir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create data dir: %w", err)
+	}
+
+	db, err := bolt.Open(filepath.Join(dataDir, dbFileName), 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{alertsBucket, jobsBucket, dlqBucket, healthBucket, historyBucket, deliveriesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize store: %w", err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Close closes the underlying database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Ping checks that the database accepts writes by committing a timestamp
+func (s *Store) Ping(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- s.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(healthBucket).Put([]byte("lastPing"), []byte(time.Now().UTC().Format(time.RFC3339Nano)))
+		})
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("store not writable: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("store not writable: %w", ctx.Err())
+	}
+}
+
+// GetAlert returns the record for alertID, or nil if the alert is unknown
+func (s *Store) GetAlert(alertID string) (*AlertRecord, error) {
+	var rec *AlertRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(alertsBucket).Get([]byte(alertID))
+		if data == nil {
+			return nil
+		}
+		var err error
+		rec, err = decodeAlert(data)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read alert %s: %w", alertID, err)
+	}
+	return rec, nil
+}
+
+// ClaimAlert atomically reserves alertID for task creation by jobID.
+// If the alert is already known, the existing record is returned and claimed is false.
+// A claim without tickets can be taken again by the job holding it, e.g. when the job
+// is resumed after a restart, and by any job once it expires after pendingClaimTimeout.
+// Likewise an alert with notifications pending is handed to jobID unless another job
+// holds it; existing.JobID tells whether jobID now holds the alert.
+func (s *Store) ClaimAlert(alertID string, jobID string, xType string, status string) (existing *AlertRecord, claimed bool, err error) {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(alertsBucket)
+		now := time.Now().UTC()
+
+		if data := b.Get([]byte(alertID)); data != nil {
+			rec, err := decodeAlert(data)
+			if err != nil {
+				return err
+			}
+			if rec.heldByOther(jobID, now) {
+				existing = rec
+				return nil
+			}
+			if len(rec.Tickets) > 0 {
+				existing = rec
+				if !rec.InProgress() || rec.JobID == jobID {
+					return nil
+				}
+				rec.JobID = jobID
+				rec.UpdatedAt = now
+				return putJSON(b, alertID, rec)
+			}
+		}
+
+		claimed = true
+		return putJSON(b, alertID, &AlertRecord{
+			AlertID:   alertID,
+			XType:     xType,
+			Status:    status,
+			JobID:     jobID,
+			CreatedAt: now,
+			UpdatedAt: now,
+		})
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to claim alert %s: %w", alertID, err)
+	}
+	return existing, claimed, nil
+}
+
+// RefreshClaim keeps the claim of jobID on alertID from expiring while the job is
+// still trying to create the alert's tickets or send its notifications
+func (s *Store) RefreshClaim(alertID string, jobID string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(alertsBucket)
+		data := b.Get([]byte(alertID))
+		if data == nil {
+			return nil
+		}
+		rec, err := decodeAlert(data)
+		if err != nil {
+			return err
+		}
+		if rec.JobID != jobID || !rec.InProgress() {
+			return nil
+		}
+		rec.UpdatedAt = time.Now().UTC()
+		return putJSON(b, alertID, rec)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to refresh claim on alert %s: %w", alertID, err)
+	}
+	return nil
+}
+
+// SetNotification records the state of notifier for alertID; an empty state removes it.
+// Unlike SaveAlert it leaves the rest of the record as stored.
+func (s *Store) SetNotification(alertID string, notifier string, state string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(alertsBucket)
+		data := b.Get([]byte(alertID))
+		if data == nil {
+			return nil
+		}
+		rec, err := decodeAlert(data)
+		if err != nil {
+			return err
+		}
+		if state == "" {
+			delete(rec.Notifications, notifier)
+		} else {
+			if rec.Notifications == nil {
+				rec.Notifications = map[string]string{}
+			}
+			rec.Notifications[notifier] = state
+		}
+		rec.UpdatedAt = time.Now().UTC()
+		return putJSON(b, alertID, rec)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record %s notification of alert %s: %w", notifier, alertID, err)
+	}
+	return nil
+}
+
+// RetryNotification marks the notification of alertID by notifier pending again and
+// releases the alert, so that the next job delivering it sends the notification
+func (s *Store) RetryNotification(alertID string, notifier string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(alertsBucket)
+		data := b.Get([]byte(alertID))
+		if data == nil {
+			return nil
+		}
+		rec, err := decodeAlert(data)
+		if err != nil {
+			return err
+		}
+		if rec.Notifications == nil {
+			rec.Notifications = map[string]string{}
+		}
+		rec.Notifications[notifier] = NotificationPending
+		rec.JobID = ""
+		rec.UpdatedAt = time.Now().UTC()
+		return putJSON(b, alertID, rec)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to retry %s notification of alert %s: %w", notifier, alertID, err)
+	}
+	return nil
+}
+
+// ReleasePendingClaims drops every claim that has no tickets yet. At startup no job
+// is running, so such claims were left by jobs interrupted by the previous shutdown.
+func (s *Store) ReleasePendingClaims() (int, error) {
+	released := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(alertsBucket)
+		var stale [][]byte
+		err := b.ForEach(func(k, data []byte) error {
+			rec, err := decodeAlert(data)
+			if err != nil {
+				return err
+			}
+			if len(rec.Tickets) == 0 {
+				stale = append(stale, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range stale {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		released = len(stale)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to release pending claims: %w", err)
+	}
+	return released, nil
+}
+
+// SaveAlert inserts or replaces the record for rec.AlertID
+func (s *Store) SaveAlert(rec *AlertRecord) error {
+	rec.UpdatedAt = time.Now().UTC()
+	if rec.CreatedAt.IsZero() {
+		rec.CreatedAt = rec.UpdatedAt
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx.Bucket(alertsBucket), rec.AlertID, rec)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save alert %s: %w", rec.AlertID, err)
+	}
+	return nil
+}
+
+// DeleteAlert removes the record for alertID
+func (s *Store) DeleteAlert(alertID string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(alertsBucket).Delete([]byte(alertID))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete alert %s: %w", alertID, err)
+	}
+	return nil
+}
+
+func decodeAlert(data []byte) (*AlertRecord, error) {
+	rec := &AlertRecord{}
+	if err := json.Unmarshal(data, rec); err != nil {
+		return nil, err
+	}
+
+	if rec.TaskID != "" {
+		if rec.Tickets == nil {
+			rec.Tickets = map[string]*TicketRef{}
+		}
+		if _, ok := rec.Tickets["clickup"]; !ok {
+			rec.Tickets["clickup"] = &TicketRef{ID: rec.TaskID, URL: rec.TaskURL, Status: rec.Status}
+		}
+		rec.TaskID, rec.TaskURL = "", ""
+	}
+
+	return rec, nil
+}
+
+func putJSON(b *bolt.Bucket, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(key), data)
+}
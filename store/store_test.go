@@ -0,0 +1,220 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func openTestStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// putAlert writes rec as stored, without SaveAlert touching its timestamps
+func putAlert(t *testing.T, s *Store, rec interface{}, alertID string) {
+	t.Helper()
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx.Bucket(alertsBucket), alertID, rec)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// age moves the last update of alertID back by d
+func age(t *testing.T, s *Store, alertID string, d time.Duration) {
+	t.Helper()
+	rec, err := s.GetAlert(alertID)
+	if err != nil || rec == nil {
+		t.Fatalf("GetAlert(%s) = %v, %v", alertID, rec, err)
+	}
+	rec.UpdatedAt = rec.UpdatedAt.Add(-d)
+	putAlert(t, s, rec, alertID)
+}
+
+func claim(t *testing.T, s *Store, alertID string, jobID string) (*AlertRecord, bool) {
+	t.Helper()
+	existing, claimed, err := s.ClaimAlert(alertID, jobID, "alerta", "open")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claimed == (existing != nil) {
+		t.Fatalf("ClaimAlert(%s, %s) = %+v, %t: want either a claim or the existing record", alertID, jobID, existing, claimed)
+	}
+	return existing, claimed
+}
+
+func TestClaimAlert(t *testing.T) {
+	s := openTestStore(t)
+
+	if _, claimed := claim(t, s, "P-1", "job-1"); !claimed {
+		t.Fatal("first claim refused")
+	}
+
+	// the claim holds against other jobs, but not against its own job resuming
+	if existing, claimed := claim(t, s, "P-1", "job-2"); claimed || existing.JobID != "job-1" {
+		t.Fatalf("second job: claimed = %t, existing = %+v", claimed, existing)
+	}
+	if _, claimed := claim(t, s, "P-1", "job-1"); !claimed {
+		t.Fatal("resumed job lost its claim")
+	}
+
+	// once a ticket is recorded, every job gets the record
+	err := s.SaveAlert(&AlertRecord{
+		AlertID: "P-1",
+		XType:   "alerta",
+		Status:  "open",
+		JobID:   "job-1",
+		Tickets: map[string]*TicketRef{"clickup": {ID: "t1", Status: "open"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, jobID := range []string{"job-1", "job-2"} {
+		existing, claimed := claim(t, s, "P-1", jobID)
+		if claimed || existing.Tickets["clickup"].ID != "t1" {
+			t.Fatalf("%s: claimed = %t, existing = %+v", jobID, claimed, existing)
+		}
+	}
+}
+
+func TestClaimAlertExpires(t *testing.T) {
+	s := openTestStore(t)
+	claim(t, s, "P-1", "job-1")
+
+	age(t, s, "P-1", pendingClaimTimeout-time.Minute)
+	if _, claimed := claim(t, s, "P-1", "job-2"); claimed {
+		t.Fatal("claim taken over before it expired")
+	}
+
+	age(t, s, "P-1", 2*time.Minute)
+	if _, claimed := claim(t, s, "P-1", "job-2"); !claimed {
+		t.Fatal("expired claim not taken over")
+	}
+	if rec, _ := s.GetAlert("P-1"); rec.JobID != "job-2" {
+		t.Fatalf("claim held by %s, want job-2", rec.JobID)
+	}
+}
+
+func TestRefreshClaim(t *testing.T) {
+	s := openTestStore(t)
+	claim(t, s, "P-1", "job-1")
+	age(t, s, "P-1", pendingClaimTimeout+time.Minute)
+
+	// only the job holding the claim can refresh it
+	if err := s.RefreshClaim("P-1", "job-2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, claimed := claim(t, s, "P-1", "job-3"); !claimed {
+		t.Fatal("refresh by another job kept the claim")
+	}
+
+	age(t, s, "P-1", pendingClaimTimeout+time.Minute)
+	if err := s.RefreshClaim("P-1", "job-3"); err != nil {
+		t.Fatal(err)
+	}
+	if _, claimed := claim(t, s, "P-1", "job-4"); claimed {
+		t.Fatal("refreshed claim taken over")
+	}
+
+	if err := s.RefreshClaim("unknown", "job-3"); err != nil {
+		t.Fatalf("RefreshClaim of unknown alert = %v", err)
+	}
+}
+
+func TestClaimAlertWithPendingNotifications(t *testing.T) {
+	s := openTestStore(t)
+	err := s.SaveAlert(&AlertRecord{
+		AlertID:       "P-1",
+		Status:        "open",
+		JobID:         "job-1",
+		Tickets:       map[string]*TicketRef{"clickup": {ID: "t1"}},
+		Notifications: map[string]string{"teams": NotificationSent, "slack": NotificationPending},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if existing, _ := claim(t, s, "P-1", "job-2"); existing.JobID != "job-1" {
+		t.Fatalf("job-2 took over a live claim: %+v", existing)
+	}
+
+	age(t, s, "P-1", pendingClaimTimeout)
+	if existing, _ := claim(t, s, "P-1", "job-2"); existing.JobID != "job-2" {
+		t.Fatalf("job-2 did not take over the expired claim: %+v", existing)
+	}
+
+	if err := s.SetNotification("P-1", "slack", NotificationSent); err != nil {
+		t.Fatal(err)
+	}
+	if existing, _ := claim(t, s, "P-1", "job-3"); existing.InProgress() || existing.JobID != "job-2" {
+		t.Fatalf("finished alert claimed by job-3: %+v", existing)
+	}
+
+	// a replayed notification is free for the next job
+	if err := s.RetryNotification("P-1", "slack"); err != nil {
+		t.Fatal(err)
+	}
+	if existing, _ := claim(t, s, "P-1", "job-3"); existing.JobID != "job-3" || existing.Notifications["slack"] != NotificationPending {
+		t.Fatalf("retried notification not handed to job-3: %+v", existing)
+	}
+}
+
+func TestReleasePendingClaims(t *testing.T) {
+	s := openTestStore(t)
+	claim(t, s, "pending", "job-1")
+	err := s.SaveAlert(&AlertRecord{AlertID: "done", Tickets: map[string]*TicketRef{"clickup": {ID: "t1"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	released, err := s.ReleasePendingClaims()
+	if err != nil || released != 1 {
+		t.Fatalf("ReleasePendingClaims = %d, %v, want 1", released, err)
+	}
+	if rec, _ := s.GetAlert("pending"); rec != nil {
+		t.Fatalf("pending claim kept: %+v", rec)
+	}
+	if rec, _ := s.GetAlert("done"); rec == nil {
+		t.Fatal("alert with tickets released")
+	}
+}
+
+func TestDecodeLegacyAlert(t *testing.T) {
+	s := openTestStore(t)
+
+	// records written before tickets were tracked per sink
+	putAlert(t, s, map[string]string{
+		"alertId": "P-1",
+		"status":  "resolved",
+		"taskId":  "abc",
+		"taskUrl": "https://app.clickup.com/t/abc",
+	}, "P-1")
+
+	rec, err := s.GetAlert("P-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := rec.Tickets["clickup"]
+	if got == nil || got.ID != "abc" || got.URL != "https://app.clickup.com/t/abc" || got.Status != "resolved" {
+		t.Fatalf("clickup ticket = %+v", got)
+	}
+	if rec.TaskID != "" || rec.TaskURL != "" {
+		t.Fatalf("legacy fields kept: %+v", rec)
+	}
+	if rec.InProgress() {
+		t.Fatal("legacy record reported in progress")
+	}
+
+	// a legacy record counts as handled
+	if existing, claimed := claim(t, s, "P-1", "job-1"); claimed || existing.Tickets["clickup"].ID != "abc" {
+		t.Fatalf("claimed = %t, existing = %+v", claimed, existing)
+	}
+}
@@ -0,0 +1,187 @@
+package templates
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+//go:embed teams_card.json
+var builtinCard []byte
+
+// bindingPattern matches ${name} or ${nested.name} expressions
+var bindingPattern = regexp.MustCompile(`\$\{\s*([A-Za-z0-9_.]+)\s*\}`)
+
+// CardTemplate is an Adaptive Card JSON document with ${...} data bindings.
+//
+// Strings may contain ${field} expressions that are replaced with values from the
+// card data; a string that is exactly one expression takes the value's JSON type.
+// An object with a "$when": "${field}" property is dropped from its parent array
+// (or object) when the field is empty, false or zero.
+type CardTemplate struct {
+	root interface{}
+}
+
+// LoadCardTemplate reads an Adaptive Card template from file, or returns the built-in
+// card for an empty path. The template is validated by rendering sample data.
+func LoadCardTemplate(file string) (*CardTemplate, error) {
+	data := builtinCard
+	if file != "" {
+		var err error
+		data, err = os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read card template: %w", err)
+		}
+	}
+
+	t, err := ParseCardTemplate(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid card template %s: %w", cardName(file), err)
+	}
+	return t, nil
+}
+
+// ParseCardTemplate parses and validates an Adaptive Card template
+func ParseCardTemplate(data []byte) (*CardTemplate, error) {
+	var root interface{}
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+
+	t := &CardTemplate{root: root}
+
+	card, err := t.Render(SampleCardData())
+	if err != nil {
+		return nil, err
+	}
+	if card["type"] != "AdaptiveCard" {
+		return nil, fmt.Errorf(`card "type" must be "AdaptiveCard", got %v`, card["type"])
+	}
+	if _, ok := card["body"].([]interface{}); !ok {
+		return nil, fmt.Errorf(`card must have a "body" array`)
+	}
+
+	return t, nil
+}
+
+// Render binds data into the template and returns the resulting card
+func (t *CardTemplate) Render(data map[string]interface{}) (map[string]interface{}, error) {
+	out, keep := bind(t.root, data)
+	card, ok := out.(map[string]interface{})
+	if !keep || !ok {
+		return nil, fmt.Errorf("card template must be a JSON object")
+	}
+	return card, nil
+}
+
+// bind returns a copy of node with bindings resolved. keep is false when the
+// node's $when condition evaluated to false.
+func bind(node interface{}, data map[string]interface{}) (out interface{}, keep bool) {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if cond, ok := n["$when"]; ok {
+			v, _ := bind(cond, data)
+			if !truthy(v) {
+				return nil, false
+			}
+		}
+
+		obj := make(map[string]interface{}, len(n))
+		for k, v := range n {
+			if k == "$when" {
+				continue
+			}
+			bound, keep := bind(v, data)
+			if !keep {
+				continue
+			}
+			// drop arrays emptied by $when so optional sections disappear entirely
+			if arr, ok := bound.([]interface{}); ok && len(arr) == 0 {
+				if orig, ok := v.([]interface{}); ok && len(orig) > 0 {
+					continue
+				}
+			}
+			obj[k] = bound
+		}
+		return obj, true
+
+	case []interface{}:
+		arr := make([]interface{}, 0, len(n))
+		for _, v := range n {
+			if bound, keep := bind(v, data); keep {
+				arr = append(arr, bound)
+			}
+		}
+		return arr, true
+
+	case string:
+		return bindString(n, data), true
+
+	default:
+		return node, true
+	}
+}
+
+func bindString(s string, data map[string]interface{}) interface{} {
+	// a lone expression keeps the bound value's type (lists, numbers, booleans)
+	if m := bindingPattern.FindStringSubmatch(s); m != nil && m[0] == strings.TrimSpace(s) {
+		return lookup(data, m[1])
+	}
+
+	return bindingPattern.ReplaceAllStringFunc(s, func(expr string) string {
+		v := lookup(data, bindingPattern.FindStringSubmatch(expr)[1])
+		if v == nil {
+			return ""
+		}
+		if str, ok := v.(string); ok {
+			return str
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(b)
+	})
+}
+
+// lookup resolves a dotted path such as "alert.policyName" in data
+func lookup(data map[string]interface{}, path string) interface{} {
+	var cur interface{} = data
+	for _, part := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		cur = m[part]
+	}
+	return cur
+}
+
+func truthy(v interface{}) bool {
+	if v == nil {
+		return false
+	}
+	switch x := v.(type) {
+	case bool:
+		return x
+	case string:
+		return x != "" && x != "false"
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Map:
+		return rv.Len() > 0
+	}
+	return !rv.IsZero()
+}
+
+func cardName(file string) string {
+	if file == "" {
+		return "(built-in)"
+	}
+	return file
+}
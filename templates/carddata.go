@@ -0,0 +1,72 @@
+package templates
+
+import (
+	"encoding/json"
+	"fmt"
+	"prisma-webhook/models"
+	"strings"
+	"time"
+)
+
+// CardData builds the values available to card templates for an alert.
+// The full alert is also exposed as "alert", keyed by its JSON field names.
+func CardData(alert *models.CustomPrismaAlert, clickupURL string, prismaURL string, webhookType string) map[string]interface{} {
+	tags := ""
+	if alert.Tags != nil {
+		tags = fmt.Sprintf("%v", alert.Tags)
+	}
+
+	var full map[string]interface{}
+	if raw, err := json.Marshal(alert); err == nil {
+		_ = json.Unmarshal(raw, &full)
+	}
+
+	return map[string]interface{}{
+		"severity":      alert.Severity,
+		"severityUpper": strings.ToUpper(alert.Severity),
+		"severityColor": SeverityColorName(alert.Severity),
+		"severityEmoji": models.SeverityEmoji(alert.Severity),
+		"policyName":    alert.PolicyName,
+		"policyId":      alert.PolicyId,
+		"policyType":    alert.PolicyType,
+		"resourceName":  alert.ResourceName,
+		"resourceId":    alert.ResourceId,
+		"resourceType":  alert.ResourceType,
+		"accountName":   alert.AccountName,
+		"accountId":     alert.AccountId,
+		"cloudType":     alert.CloudType,
+		"region":        alert.ResourceRegion,
+		"alertId":       alert.AlertId,
+		"alertStatus":   alert.AlertStatus,
+		"alertRuleName": alert.AlertRuleName,
+		"alertTime":     time.UnixMilli(alert.AlertTs).Format("2006-01-02 15:04:05 +0700"),
+		"tags":          tags,
+		"clickupUrl":    clickupURL,
+		"prismaUrl":     prismaURL,
+		"xType":         webhookType,
+		"alert":         full,
+	}
+}
+
+// SampleCardData returns card data for the sample alert, used to validate card templates
+func SampleCardData() map[string]interface{} {
+	data := CardData(SampleAlert(), "https://app.clickup.com/t/sample", "https://app.prismacloud.io/alerts/overview", "alerta")
+	data["sharepointUrl"] = "https://contoso.sharepoint.com/sites/security/SitePages/prisma-alert-P-0.aspx"
+	return data
+}
+
+// SeverityColorName returns an Adaptive Card color name for the severity level
+func SeverityColorName(severity string) string {
+	switch strings.ToLower(severity) {
+	case "critical":
+		return "Attention" // Red
+	case "high":
+		return "Attention" // Red
+	case "medium":
+		return "Warning" // Orange/Yellow
+	case "low":
+		return "Good" // Green
+	default:
+		return "Default" // Default text color
+	}
+}
@@ -0,0 +1,86 @@
+package templates
+
+import (
+	"encoding/json"
+	"fmt"
+	"prisma-webhook/models"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Funcs returns the helper functions available to every template
+func Funcs() template.FuncMap {
+	return template.FuncMap{
+		"severityEmoji": models.SeverityEmoji,
+		"toPrettyJSON":  toPrettyJSON,
+		"formatTime":    formatTime,
+		"truncate":      truncate,
+		"upper":         strings.ToUpper,
+		"lower":         strings.ToLower,
+		"join":          join,
+		"default":       defaultValue,
+	}
+}
+
+// toPrettyJSON renders v as indented JSON
+func toPrettyJSON(v interface{}) (string, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// formatTime formats a Prisma timestamp (Unix milliseconds) or a time.Time with a Go layout,
+// e.g. {{formatTime "2006-01-02 15:04" .AlertTs}}
+func formatTime(layout string, v interface{}) (string, error) {
+	switch t := v.(type) {
+	case int64:
+		return time.UnixMilli(t).Format(layout), nil
+	case int:
+		return time.UnixMilli(int64(t)).Format(layout), nil
+	case time.Time:
+		return t.Format(layout), nil
+	default:
+		return "", fmt.Errorf("formatTime: unsupported value of type %T", v)
+	}
+}
+
+// truncate shortens s to at most n characters, marking the cut with an ellipsis
+func truncate(n int, s string) string {
+	runes := []rune(s)
+	if n <= 0 || len(runes) <= n {
+		return s
+	}
+	if n == 1 {
+		return "…"
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+// join concatenates the elements of a list with sep
+func join(sep string, v interface{}) string {
+	switch list := v.(type) {
+	case []string:
+		return strings.Join(list, sep)
+	case []fiber.Map:
+		parts := make([]string, 0, len(list))
+		for _, item := range list {
+			parts = append(parts, fmt.Sprintf("%v=%v", item["key"], item["value"]))
+		}
+		return strings.Join(parts, sep)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// defaultValue returns def when v is empty, e.g. {{.ResourceRegion | default "global"}}
+func defaultValue(def string, v interface{}) interface{} {
+	if v == nil || v == "" {
+		return def
+	}
+	return v
+}
@@ -0,0 +1,52 @@
+package templates
+
+import (
+	"prisma-webhook/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// SampleAlert returns a fully populated alert used to validate templates at startup
+func SampleAlert() *models.CustomPrismaAlert {
+	return &models.CustomPrismaAlert{
+		ResourceId:                     "arn:aws:s3:::sample-bucket",
+		AlertRuleName:                  "Sample Alert Rule",
+		Anomaly:                        fiber.Map{"sample": true},
+		AccountName:                    "sample-account",
+		HasFinding:                     true,
+		ResourceRegionId:               "us-east-1",
+		AlertRemediationCli:            "aws s3api put-public-access-block --bucket sample-bucket",
+		AlertRemediationCliDescription: "Blocks public access to the bucket.",
+		AlertRemediationImpact:         "Public clients lose access.",
+		Source:                         "Prisma Cloud",
+		CloudType:                      "aws",
+		CallbackUrl:                    "https://app.prismacloud.io/alerts/overview",
+		AlertId:                        "P-0",
+		PolicyLabels:                   []string{"sample"},
+		AlertAttribution:               fiber.Map{"attributionEventList": []string{}},
+		Severity:                       "high",
+		PolicyName:                     "Sample policy",
+		Resource:                       fiber.Map{"name": "sample-bucket"},
+		ResourceName:                   "sample-bucket",
+		ResourceRegion:                 "AWS Virginia",
+		PolicyDescription:              "Sample policy description.",
+		PolicyRecommendation:           "Sample recommendation.",
+		AccountId:                      "123456789012",
+		PolicyId:                       "00000000-0000-0000-0000-000000000000",
+		ResourceCloudService:           "Amazon S3",
+		AlertTs:                        1700000000000,
+		FirstSeen:                      1700000000000,
+		LastSeen:                       1700000000000,
+		ResourceType:                   "s3",
+		AdditionalInfo:                 fiber.Map{"sample": "info"},
+		Reason:                         "NEW_ALERT",
+		AlertStatus:                    "open",
+		AlertDismissalNote:             "",
+		AlertRuleId:                    "00000000-0000-0000-0000-000000000001",
+		Tags:                           []fiber.Map{{"key": "env", "value": "sample"}},
+		FindingSummary:                 fiber.Map{"sample": 1},
+		PolicyType:                     "config",
+		AccountOwners:                  "owner@example.com",
+		AccountAncestors:               "root",
+	}
+}
@@ -0,0 +1,88 @@
+package templates
+
+import (
+	"embed"
+	"fmt"
+	"os"
+	"prisma-webhook/models"
+	"strings"
+	"text/template"
+)
+
+//go:embed task_title.tmpl task_description.tmpl
+var builtinTask embed.FS
+
+// TaskRenderer renders ClickUp task titles and descriptions from Go text/templates.
+// The alert (models.CustomPrismaAlert) is the template's dot.
+type TaskRenderer struct {
+	title       *template.Template
+	description *template.Template
+}
+
+// NewTaskRenderer loads the title and description templates from the given files,
+// falling back to the built-in templates for empty paths. Templates are validated
+// by rendering a sample alert.
+func NewTaskRenderer(titleFile string, descriptionFile string) (*TaskRenderer, error) {
+	title, err := loadTemplate("title", titleFile, "task_title.tmpl")
+	if err != nil {
+		return nil, err
+	}
+
+	description, err := loadTemplate("description", descriptionFile, "task_description.tmpl")
+	if err != nil {
+		return nil, err
+	}
+
+	r := &TaskRenderer{title: title, description: description}
+
+	sample := SampleAlert()
+	if _, err := r.Title(sample); err != nil {
+		return nil, fmt.Errorf("invalid task title template: %w", err)
+	}
+	if _, err := r.Description(sample); err != nil {
+		return nil, fmt.Errorf("invalid task description template: %w", err)
+	}
+
+	return r, nil
+}
+
+// Title renders the task title for alert
+func (r *TaskRenderer) Title(alert *models.CustomPrismaAlert) (string, error) {
+	title, err := execute(r.title, alert)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(title), nil
+}
+
+// Description renders the markdown task description for alert
+func (r *TaskRenderer) Description(alert *models.CustomPrismaAlert) (string, error) {
+	return execute(r.description, alert)
+}
+
+func loadTemplate(name string, file string, builtinName string) (*template.Template, error) {
+	var text []byte
+	var err error
+	if file != "" {
+		text, err = os.ReadFile(file)
+	} else {
+		text, err = builtinTask.ReadFile(builtinName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read task %s template: %w", name, err)
+	}
+
+	tmpl, err := template.New(name).Funcs(Funcs()).Parse(string(text))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse task %s template: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+func execute(tmpl *template.Template, data interface{}) (string, error) {
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}